@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/hrodrig/hbactl/internal/hba"
-	"github.com/hrodrig/hbactl/internal/pg"
 	"github.com/spf13/cobra"
 )
 
@@ -18,15 +18,26 @@ var (
 	addAddr      string
 	addNetmask   string
 	addMethod    string
-	addIdentMap   string
-	addDryRun     bool
-	addAfterUser  string
+	addIdentMap  string
+	addOptions   []string
+	addDryRun    bool
+	addAfterUser string
+
+	addTop         bool
+	addBeforeIndex int
+	addAfterIndex  int
+	addBeforeLine  string
+	addBeforeMatch string
+	addAfterMatch  string
 )
 
+// placementFlags are the flags of add that choose where the rule goes; at most one may be used.
+var placementFlags = []string{"after-user", "top", "before-index", "after-index", "before-line", "before-match", "after-match"}
+
 var addCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Add a rule to pg_hba.conf",
-	Long:  "Appends a new rule to pg_hba.conf. Creates a backup before writing. Run 'hbactl reload' to apply changes. Use --dry-run to preview without writing.",
+	Long:  "Appends a new rule to pg_hba.conf, or puts it where a placement flag says: --top, --before-index/--after-index N, --before-line LINE, --before-match/--after-match FILTER or --after-user NAME. Since the first matching rule wins, this is how a rule is put above a catch-all reject. A rule inserted before another one goes above the comment lines directly preceding it. The rule is validated first (for the server's PostgreSQL version, or --pg-version) and refused if PostgreSQL would reject it. Creates a backup before writing. Run 'hbactl reload' to apply changes. Use --dry-run to preview without writing.",
 	RunE:  runAdd,
 }
 
@@ -34,108 +45,277 @@ func init() {
 	rootCmd.AddCommand(addCmd)
 	addCmd.Flags().StringVar(&addType, "type", "", "Rule type: local, host, hostssl, hostnossl, hostgssenc, hostnogssenc")
 	addCmd.Flags().StringVar(&addDB, "db", "all", "Database (e.g. all, sameuser, or name)")
-	addCmd.Flags().StringVar(&addUser, "user", "all", "User: all, a user name, or a group/role with + prefix (e.g. +admins)")
+	addCmd.Flags().StringVar(&addUser, "user", "all", "User: all, a user name, or a group/role with + prefix (e.g. +admins); quote a name to keep it from being read as a keyword (e.g. '\"all\"')")
 	addCmd.Flags().StringVar(&addAddr, "addr", "", "Address: CIDR (e.g. 127.0.0.1/32), samehost, samenet; use - for local")
 	addCmd.Flags().StringVar(&addNetmask, "netmask", "", "Optional: legacy netmask (e.g. 255.255.255.0)")
 	addCmd.Flags().StringVar(&addMethod, "method", "", "Auth method: trust, reject, scram-sha-256, md5, ident, etc.")
-	addCmd.Flags().StringVar(&addIdentMap, "ident-map", "", "For method ident: username map name (e.g. my_ident_map → writes 'ident my_ident_map')")
+	addCmd.Flags().StringArrayVar(&addOptions, "option", nil, "Auth option as name=value (e.g. clientcert=verify-full); repeat for several, written in order")
+	addCmd.Flags().StringVar(&addIdentMap, "ident-map", "", "Username map name, shorthand for --option map=NAME (e.g. my_ident_map → writes 'ident map=my_ident_map')")
 	addCmd.Flags().BoolVar(&addDryRun, "dry-run", false, "Print the line that would be added without writing or creating backup")
 	addCmd.Flags().StringVar(&addAfterUser, "after-user", "", "Insert after the last rule for this user (keeps rules grouped by user); default appends at end")
+	addCmd.Flags().BoolVar(&addTop, "top", false, "Insert before the first rule, so it is evaluated first")
+	addCmd.Flags().IntVar(&addBeforeIndex, "before-index", 0, "Insert before rule N (see the # column of 'hbactl list')")
+	addCmd.Flags().IntVar(&addAfterIndex, "after-index", 0, "Insert after rule N (see the # column of 'hbactl list')")
+	addCmd.Flags().StringVar(&addBeforeLine, "before-line", "", "Insert before this line of the top-level file, or FILE:LINE for an included file (one past the last line appends)")
+	addCmd.Flags().StringVar(&addBeforeMatch, "before-match", "", "Insert before the first rule matching this filter (e.g. 'method=reject', 'user=alice db=app'; fields: type, db, user, addr, method)")
+	addCmd.Flags().StringVar(&addAfterMatch, "after-match", "", "Insert after the last rule matching this filter (fields: type, db, user, addr, method)")
 	_ = addCmd.MarkFlagRequired("type")
 	_ = addCmd.MarkFlagRequired("method")
 }
 
 func runAdd(cmd *cobra.Command, _ []string) error {
-	typ := strings.ToLower(strings.TrimSpace(addType))
-	method := strings.TrimSpace(addMethod)
-	identMap := strings.TrimSpace(addIdentMap)
-	if identMap != "" && strings.ToLower(method) == "ident" {
-		method = "ident " + identMap
+	var options []hba.Option
+	for _, s := range addOptions {
+		o, err := hba.ParseOption(s)
+		if err != nil {
+			return err
+		}
+		options = append(options, o)
 	}
-	db := strings.TrimSpace(addDB)
-	if db == "" {
-		db = "all"
+	if identMap := strings.TrimSpace(addIdentMap); identMap != "" {
+		options = append(options, hba.Option{Name: "map", Value: identMap})
 	}
-	user := strings.TrimSpace(addUser)
-	if user == "" {
-		user = "all"
+	rule, err := hba.NewRule(addType, addDB, addUser, addAddr, addNetmask, addMethod, options)
+	if err != nil {
+		return err
 	}
-	addr := strings.TrimSpace(addAddr)
-	netmask := strings.TrimSpace(addNetmask)
-
-	if !hba.LocalType(typ) && !hba.HostType(typ) {
-		return fmt.Errorf("invalid type %q; use one of: local, host, hostssl, hostnossl, hostgssenc, hostnogssenc", typ)
+	v, err := pgVersion(context.Background())
+	if err != nil {
+		return err
 	}
-	if hba.LocalType(typ) {
-		addr = "-"
-		netmask = ""
-	} else {
-		if addr == "" {
-			return fmt.Errorf("addr is required for type %s (e.g. 127.0.0.1/32, samehost)", typ)
+	if errs := rule.Validate(v); len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
 		}
+		return fmt.Errorf("rule would be rejected by PostgreSQL (target version %s); nothing written", v)
+	}
+
+	placed, err := checkPlacement(cmd)
+	if err != nil {
+		return err
 	}
 
 	path := filePath()
-	if path == "" && !addDryRun {
-		conn := connString()
-		if conn == "" {
-			return fmt.Errorf("no connection: set DATABASE_URL or use --conn (or pass path with --file)")
-		}
-		ctx := context.Background()
-		client, err := pg.NewClient(ctx, conn)
+	if path == "" && (!addDryRun || placed) {
+		p, err := hbaPath(context.Background())
 		if err != nil {
-			return fmt.Errorf("could not connect to PostgreSQL: %w", err)
-		}
-		defer client.Close()
-		var p string
-		p, err = client.HBAFilePath(ctx)
-		if err != nil {
-			return fmt.Errorf("could not locate pg_hba.conf. Is PostgreSQL running? %w", err)
+			return err
 		}
 		path = p
 	}
+	if addDryRun && placed {
+		// Where the rule goes depends on the file, so it is read and the rule inserted in memory.
+		return addRule(path, rule)
+	}
 	if addDryRun {
 		if path == "" {
 			path = "(path from --file or connection)"
 		}
-		rule := hba.Rule{Type: typ, Database: db, User: user, Address: addr, Netmask: netmask, Method: method}
 		line := rule.Line()
-		if line == "" {
-			return fmt.Errorf("invalid rule type %q", typ)
-		}
 		if addAfterUser != "" {
-			fmt.Fprintf(os.Stdout, "dry-run: would insert after last rule for user %q in %s:\n%s\n", strings.TrimSpace(addAfterUser), path, line)
+			fmt.Fprintf(msgOut(), "dry-run: would insert after last rule for user %q in %s:\n%s\n", strings.TrimSpace(addAfterUser), path, line)
 		} else {
-			fmt.Fprintf(os.Stdout, "dry-run: would append to %s:\n%s\n", path, line)
+			fmt.Fprintf(msgOut(), "dry-run: would append to %s:\n%s\n", path, line)
 		}
 		return nil
 	}
 
-	backupPath, err := hba.Backup(path)
+	unlock, err := lockServer(context.Background())
 	if err != nil {
-		if os.IsPermission(err) {
-			return fmt.Errorf("insufficient permissions to write to pg_hba.conf. Try running with sudo")
-		}
-		return fmt.Errorf("backup failed: %w", err)
+		return err
+	}
+	defer unlock()
+	lock, err := lockFile(path)
+	if err != nil {
+		return err
 	}
-	fmt.Fprintf(os.Stderr, "Backup created at: %s\n", backupPath)
+	defer lock.Release()
+	p := placement()
+	indexed := p == "before-index" || p == "after-index" || p == "before-line"
+	return retryUnlessIndexed(indexed, func() error { return addRule(path, rule) })
+}
 
-	rule := hba.Rule{Type: typ, Database: db, User: user, Address: addr, Netmask: netmask, Method: method}
+// addRule reads the configuration at path, adds rule and saves it.
+func addRule(path string, rule hba.Rule) error {
+	cfg, err := loadConfig(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	if placement() != "" {
+		return placeRule(cfg, rule)
+	}
+	var doc *hba.Document
 	if addAfterUser != "" {
 		afterUser := strings.TrimSpace(addAfterUser)
-		if err := hba.InsertRuleAfterUser(path, rule, afterUser); err != nil {
-			if os.IsPermission(err) {
-				return fmt.Errorf("insufficient permissions to write to pg_hba.conf. Try running with sudo")
-			}
+		if doc, err = cfg.InsertRuleAfterUser(rule, afterUser); err != nil {
 			return fmt.Errorf("failed to insert rule after user %q: %w", afterUser, err)
 		}
-	} else if err := hba.AppendRule(path, rule); err != nil {
-		if os.IsPermission(err) {
-			return fmt.Errorf("insufficient permissions to write to pg_hba.conf. Try running with sudo")
-		}
+	} else if doc, err = cfg.AppendRule(rule); err != nil {
 		return fmt.Errorf("failed to append rule: %w", err)
 	}
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(msgOut(), "Success: New rule added to %s. Run 'hbactl reload' to apply changes.\n", doc.Path)
+	return nil
+}
 
-	fmt.Fprintf(os.Stdout, "Success: New rule added to %s. Run 'hbactl reload' to apply changes.\n", path)
+// checkPlacement checks the placement flags other than --after-user and reports whether one of them
+// is used.
+func checkPlacement(cmd *cobra.Command) (bool, error) {
+	var set []string
+	for _, name := range placementFlags {
+		if cmd.Flags().Changed(name) {
+			set = append(set, "--"+name)
+		}
+	}
+	if len(set) > 1 {
+		return false, fmt.Errorf("use only one placement flag (got %s)", strings.Join(set, ", "))
+	}
+	if (cmd.Flags().Changed("before-index") && addBeforeIndex < 1) || (cmd.Flags().Changed("after-index") && addAfterIndex < 1) {
+		return false, fmt.Errorf("--before-index and --after-index must be >= 1")
+	}
+	if addBeforeLine != "" {
+		if _, _, err := parseFileLine(addBeforeLine); err != nil {
+			return false, err
+		}
+	}
+	for _, f := range []string{addBeforeMatch, addAfterMatch} {
+		if f != "" {
+			if _, err := hba.ParseFilter(f); err != nil {
+				return false, err
+			}
+		}
+	}
+	return placement() != "", nil
+}
+
+// placement returns the placement flag in use other than --after-user, if any.
+func placement() string {
+	switch {
+	case addTop:
+		return "top"
+	case addBeforeIndex > 0:
+		return "before-index"
+	case addAfterIndex > 0:
+		return "after-index"
+	case addBeforeLine != "":
+		return "before-line"
+	case addBeforeMatch != "":
+		return "before-match"
+	case addAfterMatch != "":
+		return "after-match"
+	}
+	return ""
+}
+
+// parseFileLine parses a --before-line value: LINE (of the top-level file) or FILE:LINE.
+func parseFileLine(s string) (file string, line int, err error) {
+	lineStr := s
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		file, lineStr = s[:i], s[i+1:]
+	}
+	line, err = strconv.Atoi(strings.TrimSpace(lineStr))
+	if err != nil || line < 1 {
+		return "", 0, fmt.Errorf("invalid --before-line %q: use LINE or FILE:LINE with LINE >= 1", s)
+	}
+	return file, line, nil
+}
+
+// placeRule inserts rule into cfg where the placement flag says and saves cfg, or with --dry-run
+// reports exactly where the rule would go.
+func placeRule(cfg *hba.Config, rule hba.Rule) error {
+	rules := cfg.Rules()
+	// anchor describes rule x as it is before the insertion; with above set, it also mentions the
+	// comment lines directly above x, which the new rule goes above too.
+	anchor := func(x hba.RuleWithLine, above bool) string {
+		s := fmt.Sprintf("rule #%d (%s: %s)", x.Index, ruleLocation(cfg, x), strings.Join(strings.Fields(x.Rule.Line()), " "))
+		if d := cfg.Document(x.File); above && d != nil && d.CommentStart(x.Pos) < x.Pos {
+			s += fmt.Sprintf(" and the %d comment line(s) above it", x.Pos-d.CommentStart(x.Pos))
+		}
+		return s
+	}
+	byIndex := func(index int) (hba.RuleWithLine, error) {
+		x, ok := cfg.Rule(index)
+		if !ok {
+			return x, fmt.Errorf("no rule at index %d (file has %d rule(s)); run 'hbactl list' to see indices", index, len(rules))
+		}
+		return x, nil
+	}
+	byFilter := func(s string, last bool) (hba.RuleWithLine, error) {
+		f, _ := hba.ParseFilter(s) // checked by checkPlacement
+		var found *hba.RuleWithLine
+		for i := range rules {
+			if f.Match(rules[i]) {
+				found = &rules[i]
+				if !last {
+					break
+				}
+			}
+		}
+		if found == nil {
+			return hba.RuleWithLine{}, fmt.Errorf("no rule matches %q", f)
+		}
+		return *found, nil
+	}
+
+	var x, inserted hba.RuleWithLine
+	var where string
+	var err error
+	switch placement() {
+	case "top":
+		if len(rules) == 0 {
+			where = "at the end of the file, which has no rules yet"
+			inserted, err = cfg.InsertRuleAtLine(rule, cfg.Root.Path, cfg.Root.LineCount()+1)
+			break
+		}
+		where = "at the top, before " + anchor(rules[0], true)
+		inserted, err = cfg.InsertRuleBefore(rule, rules[0])
+	case "before-index":
+		if x, err = byIndex(addBeforeIndex); err == nil {
+			where = "before " + anchor(x, true)
+			inserted, err = cfg.InsertRuleBefore(rule, x)
+		}
+	case "after-index":
+		if x, err = byIndex(addAfterIndex); err == nil {
+			where = "after " + anchor(x, false)
+			inserted, err = cfg.InsertRuleAfter(rule, x)
+		}
+	case "before-match":
+		if x, err = byFilter(addBeforeMatch, false); err == nil {
+			where = "before the first rule matching the filter, " + anchor(x, true)
+			inserted, err = cfg.InsertRuleBefore(rule, x)
+		}
+	case "after-match":
+		if x, err = byFilter(addAfterMatch, true); err == nil {
+			where = "after the last rule matching the filter, " + anchor(x, false)
+			inserted, err = cfg.InsertRuleAfter(rule, x)
+		}
+	case "before-line":
+		file, line, _ := parseFileLine(addBeforeLine) // checked by checkPlacement
+		if file == "" {
+			file = cfg.Root.Path
+		}
+		if d := cfg.Document(file); d != nil && line == d.LineCount()+1 {
+			where = "at the end of the file"
+		} else {
+			where = fmt.Sprintf("before line %d", line)
+		}
+		inserted, err = cfg.InsertRuleAtLine(rule, file, line)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert rule: %w", err)
+	}
+
+	text := cfg.Node(inserted).Text()
+	if addDryRun {
+		fmt.Fprintf(msgOut(), "dry-run: would insert into %s at line %d as rule #%d, %s:\n%s\n", inserted.File, inserted.LineNo, inserted.Index, where, text)
+		return nil
+	}
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(msgOut(), "Success: New rule added to %s at line %d as rule #%d, %s. Run 'hbactl reload' to apply changes.\n", inserted.File, inserted.LineNo, inserted.Index, where)
 	return nil
 }
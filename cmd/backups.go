@@ -0,0 +1,355 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hrodrig/hbactl/internal/diff"
+	"github.com/hrodrig/hbactl/internal/hba"
+	"github.com/spf13/cobra"
+)
+
+var (
+	backupsAt     string
+	backupsDryRun bool
+	backupsForce  bool
+	backupsReload bool
+)
+
+var backupsCmd = &cobra.Command{
+	Use:   "backups",
+	Short: "List, inspect and restore backups of pg_hba.conf",
+	Long:  "Backups are made before every edit (see --backup-dir). A backup is named by its number in 'hbactl backups list' (1 is the newest), its file name or its timestamp; --at TIME selects the backup holding the file as it was at TIME instead.",
+}
+
+var backupsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List backups, newest first, with size, rule count and checksum status",
+	Args:  cobra.NoArgs,
+	RunE:  runBackupsList,
+}
+
+var backupsShowCmd = &cobra.Command{
+	Use:   "show [ID]",
+	Short: "Print the content of a backup (or of the file at --at TIME)",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runBackupsShow,
+}
+
+var backupsDiffCmd = &cobra.Command{
+	Use:   "diff [ID]",
+	Short: "Show what changed in the current file since a backup (unified diff)",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runBackupsDiff,
+}
+
+var backupsRestoreCmd = &cobra.Command{
+	Use:   "restore [ID]",
+	Short: "Replace the current file with a backup",
+	Long:  "Replaces pg_hba.conf with the content of a backup, after backing up the current file. A backup whose checksum does not match, or that was taken of another file, is refused unless --force. With a connection, offers to reload PostgreSQL afterwards (--reload reloads without asking).",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runBackupsRestore,
+}
+
+func init() {
+	rootCmd.AddCommand(backupsCmd)
+	backupsCmd.AddCommand(backupsListCmd, backupsShowCmd, backupsDiffCmd, backupsRestoreCmd)
+	for _, c := range []*cobra.Command{backupsShowCmd, backupsDiffCmd, backupsRestoreCmd} {
+		c.Flags().StringVar(&backupsAt, "at", "", "Select the file as it was at this time (e.g. '2024-05-01 14:30', RFC 3339) instead of an ID")
+	}
+	backupsRestoreCmd.Flags().BoolVar(&backupsDryRun, "dry-run", false, "Print the changes restoring would make without writing")
+	backupsRestoreCmd.Flags().BoolVar(&backupsForce, "force", false, "Restore even if the backup does not match its checksum or was taken of another file")
+	backupsRestoreCmd.Flags().BoolVar(&backupsReload, "reload", false, "Reload PostgreSQL after restoring without asking")
+}
+
+// backupsPath returns the file whose backups the backups commands work on.
+func backupsPath() (string, error) {
+	if filterMode() {
+		return "", fmt.Errorf("backups need a file: --file - is not supported")
+	}
+	return hbaPath(context.Background())
+}
+
+func runBackupsList(cmd *cobra.Command, _ []string) error {
+	path, err := backupsPath()
+	if err != nil {
+		return err
+	}
+	backups, err := hba.Backups(path, backupOpts)
+	if err != nil {
+		return fmt.Errorf("could not list backups: %w", err)
+	}
+	if len(backups) == 0 {
+		fmt.Fprintf(os.Stdout, "No backups of %s in %s\n", path, hba.BackupDir(path, backupOpts))
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tTIME\tSIZE\tRULES\tCHECKSUM\tFILE")
+	fmt.Fprintln(tw, "-\t----\t----\t-----\t--------\t----")
+	for i := len(backups) - 1; i >= 0; i-- {
+		b := backups[i]
+		rules := "?"
+		if data, err := b.Read(); err == nil {
+			if doc, err := hba.ReadDocument(bytes.NewReader(data), b.Path); err == nil {
+				rules = strconv.Itoa(len(doc.Rules()))
+			}
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\t%s\n", len(backups)-i, formatTime(b.Time), b.Size, rules, checksumStatus(b), filepath.Base(b.Path))
+	}
+	tw.Flush()
+	return nil
+}
+
+func runBackupsShow(cmd *cobra.Command, args []string) error {
+	path, err := backupsPath()
+	if err != nil {
+		return err
+	}
+	b, err := selectBackup(path, args)
+	if err != nil {
+		return err
+	}
+	data, err := backupContent(path, b)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func runBackupsDiff(cmd *cobra.Command, args []string) error {
+	path, err := backupsPath()
+	if err != nil {
+		return err
+	}
+	b, err := selectBackup(path, args)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		fmt.Fprintf(os.Stdout, "%s has not been edited by hbactl since %s\n", path, backupsAt)
+		return nil
+	}
+	old, err := backupContent(path, b)
+	if err != nil {
+		return err
+	}
+	cur, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read file (try running with sudo?): %w", err)
+	}
+	d := diff.Unified(b.Path, path, string(old), string(cur))
+	if d == "" {
+		fmt.Fprintf(os.Stdout, "No differences between %s and %s\n", filepath.Base(b.Path), path)
+		return nil
+	}
+	fmt.Fprint(os.Stdout, d)
+	return nil
+}
+
+func runBackupsRestore(cmd *cobra.Command, args []string) error {
+	path, err := backupsPath()
+	if err != nil {
+		return err
+	}
+	b, err := selectBackup(path, args)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		fmt.Fprintf(os.Stdout, "%s has not been edited by hbactl since %s; nothing to restore\n", path, backupsAt)
+		return nil
+	}
+	if err := b.Verify(); errors.Is(err, hba.ErrNoChecksum) {
+		fmt.Fprintf(os.Stderr, "Warning: %s has no checksum file; its integrity cannot be verified\n", b.Path)
+	} else if err != nil && !backupsForce {
+		return fmt.Errorf("%w; refusing to restore it (use --force to restore anyway)", err)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if err := checkBackupSource(path, b); err != nil {
+		return err
+	}
+	data, err := b.Read()
+	if err != nil {
+		return fmt.Errorf("could not read backup: %w", err)
+	}
+
+	ctx := context.Background()
+	v, err := pgVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if doc, err := hba.ReadDocument(bytes.NewReader(data), b.Path); err == nil {
+		doc.Version = v
+		for _, d := range doc.Validate() {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", d, d.Text)
+		}
+	}
+
+	if backupsDryRun {
+		cur, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read file (try running with sudo?): %w", err)
+		}
+		d := diff.Unified(path, b.Path, string(cur), string(data))
+		if d == "" {
+			fmt.Fprintf(os.Stdout, "dry-run: %s already matches %s\n", path, filepath.Base(b.Path))
+			return nil
+		}
+		fmt.Fprintf(os.Stdout, "dry-run: restoring %s would make these changes to %s:\n%s", filepath.Base(b.Path), path, d)
+		return nil
+	}
+
+	unlock, err := lockServer(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	lock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	write := func(p string) error { return hba.WriteFileAtomic(p, data, 0600) }
+	if err := flushJournal(backupAndWrite(path, write)); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Success: %s restored from %s (%s).\n", path, filepath.Base(b.Path), formatTime(b.Time))
+
+	return offerReload(ctx, backupsReload)
+}
+
+// selectBackup returns the backup named by args[0] or, with --at, the backup holding the file as it
+// was at that time: the first one taken after it. That is nil when the file has not been edited
+// since (the current file is the answer).
+func selectBackup(path string, args []string) (*hba.BackupFile, error) {
+	if (len(args) == 1) == (backupsAt != "") {
+		return nil, fmt.Errorf("specify a backup ID (see 'hbactl backups list') or --at TIME")
+	}
+	backups, err := hba.Backups(path, backupOpts)
+	if err != nil {
+		return nil, fmt.Errorf("could not list backups: %w", err)
+	}
+	if len(backups) == 0 {
+		return nil, fmt.Errorf("no backups of %s in %s", path, hba.BackupDir(path, backupOpts))
+	}
+	if backupsAt != "" {
+		at, err := parseTime(backupsAt)
+		if err != nil {
+			return nil, err
+		}
+		for i := range backups {
+			if backups[i].Time.After(at) {
+				if i == 0 {
+					fmt.Fprintf(os.Stderr, "Warning: %s is older than every backup; using the oldest one (%s)\n", backupsAt, formatTime(backups[0].Time))
+				}
+				return &backups[i], nil
+			}
+		}
+		return nil, nil
+	}
+
+	id := args[0]
+	if n, err := strconv.Atoi(id); err == nil {
+		if n < 1 || n > len(backups) {
+			return nil, fmt.Errorf("no backup #%d (there are %d); run 'hbactl backups list'", n, len(backups))
+		}
+		return &backups[len(backups)-n], nil
+	}
+	var found *hba.BackupFile
+	for i := range backups {
+		b := &backups[i]
+		name := filepath.Base(b.Path)
+		if b.Path == id || name == id || strings.HasPrefix(name, filepath.Base(path)+".bak."+id) {
+			if found != nil {
+				return nil, fmt.Errorf("%q matches several backups (%s, %s); give more of the name", id, filepath.Base(found.Path), name)
+			}
+			found = b
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no backup %q; run 'hbactl backups list'", id)
+	}
+	return found, nil
+}
+
+// checkBackupSource refuses, unless --force, a backup whose checksum file says it was taken of a
+// file other than path (e.g. another cluster's pg_hba.conf sharing --backup-dir).
+func checkBackupSource(path string, b *hba.BackupFile) error {
+	source, err := b.Source()
+	if err != nil || source == "" {
+		return nil // made by an older hbactl: nothing to check against
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if source == abs {
+		return nil
+	}
+	if !backupsForce {
+		return fmt.Errorf("%s is a backup of %s, not of %s; refusing to restore it (use --force to restore anyway)", filepath.Base(b.Path), source, abs)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s is a backup of %s, not of %s\n", filepath.Base(b.Path), source, abs)
+	return nil
+}
+
+// backupContent returns the content of b, or of the current file when b is nil.
+func backupContent(path string, b *hba.BackupFile) ([]byte, error) {
+	if b == nil {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read file (try running with sudo?): %w", err)
+		}
+		return data, nil
+	}
+	if err := b.Verify(); err != nil && !errors.Is(err, hba.ErrNoChecksum) {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	data, err := b.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read backup: %w", err)
+	}
+	return data, nil
+}
+
+// checksumStatus describes the result of b.Verify for backups list.
+func checksumStatus(b hba.BackupFile) string {
+	switch err := b.Verify(); {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, hba.ErrNoChecksum):
+		return "none"
+	case errors.Is(err, hba.ErrChecksumMismatch):
+		return "MISMATCH"
+	default:
+		return "unreadable"
+	}
+}
+
+// timeLayouts are the formats accepted by parseTime, in local time unless they carry a zone.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+// parseTime parses a --at value.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(s), time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (e.g. '2024-05-01 14:30', 2024-05-01 or 2024-05-01T14:30:00Z)", s)
+}
+
+// formatTime formats a backup time in local time, to the millisecond.
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05.000")
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hrodrig/hbactl/internal/batch"
+	"github.com/hrodrig/hbactl/internal/diff"
+	"github.com/hrodrig/hbactl/internal/hba"
+	"github.com/spf13/cobra"
+)
+
+var applyBatchDryRun bool
+
+var applyBatchCmd = &cobra.Command{
+	Use:   "apply-batch FILE",
+	Short: "Apply a change set of add, remove and move operations as one edit",
+	Long: `Reads a change set in YAML (or JSON) from FILE, or from stdin with -, and applies its operations in order to one reading of pg_hba.conf:
+
+  reason: OPS-1234 onboard alice   # used for the journal unless --reason is given
+  operations:
+    - add: {type: host, db: app, user: alice, addr: 10.0.1.7/32, method: scram-sha-256, after_user: bob}
+    - remove: {index: 3}           # or {user: NAME, db: NAME} or {addr: ADDRESS}
+    - move: {index: 5, before: 2}  # or after: N, or to: N
+
+Indices and remove criteria refer to the rules as 'hbactl list' shows them before the batch, whatever earlier operations did. add accepts type, db, user, addr, netmask, method, options (a list of name=value) and after_user, like 'hbactl add'.
+
+If any operation fails (e.g. an added rule PostgreSQL would reject, or an index that no longer exists), nothing is written. Otherwise the result is shown as one diff and written with one backup per changed file; should writing a later file fail, the files already written are restored. Use --dry-run to preview.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runApplyBatch,
+}
+
+func init() {
+	rootCmd.AddCommand(applyBatchCmd)
+	applyBatchCmd.Flags().BoolVar(&applyBatchDryRun, "dry-run", false, "Show the planned operations and the diff without writing or creating backups")
+}
+
+func runApplyBatch(cmd *cobra.Command, args []string) error {
+	b, err := readBatch(args[0])
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		reason = b.Reason
+	}
+	ctx := context.Background()
+	path, err := hbaPath(ctx)
+	if err != nil {
+		return err
+	}
+	if !applyBatchDryRun {
+		unlock, err := lockServer(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		lock, err := lockFile(path)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+	return retryUnlessIndexed(b.UsesIndex(), func() error { return applyBatch(ctx, path, b) })
+}
+
+// readBatch reads and parses the change set in name (- for stdin).
+func readBatch(name string) (*batch.Batch, error) {
+	var r io.Reader = os.Stdin
+	if name == "-" {
+		if filterMode() {
+			return nil, fmt.Errorf("the change set and pg_hba.conf cannot both be read from stdin; pass the change set as a file")
+		}
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := batch.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid change set %s: %w", name, err)
+	}
+	return b, nil
+}
+
+// applyBatch reads the configuration at path, applies b to it, shows the result and saves it.
+func applyBatch(ctx context.Context, path string, b *batch.Batch) error {
+	cfg, err := loadConfig(ctx, path)
+	if err != nil {
+		return err
+	}
+	before := make(map[*hba.Document]string, len(cfg.Docs))
+	for _, doc := range cfg.Docs {
+		before[doc] = string(doc.Bytes())
+	}
+	steps, err := b.Apply(cfg, cfg.Version)
+	if err != nil {
+		return fmt.Errorf("%w; nothing written", err)
+	}
+
+	out := msgOut()
+	prefix := ""
+	if applyBatchDryRun {
+		prefix = "dry-run: "
+	}
+	fmt.Fprintf(out, "%s%d operation(s) planned:\n", prefix, len(steps))
+	for i, s := range steps {
+		fmt.Fprintf(out, "  %d. %s\n", i+1, s)
+	}
+	changed := cfg.Changed()
+	for _, doc := range changed {
+		fmt.Fprint(out, diff.Unified(doc.Path, doc.Path+" (batch)", before[doc], string(doc.Bytes())))
+	}
+	if len(changed) == 0 {
+		fmt.Fprintln(out, "No changes.")
+	}
+	if applyBatchDryRun || (len(changed) == 0 && !filterMode()) {
+		return nil
+	}
+
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Success: %d operation(s) applied to %s. Run 'hbactl reload' to apply changes.\n", len(steps), path)
+	return nil
+}
@@ -12,7 +12,7 @@ import (
 var checkCmd = &cobra.Command{
 	Use:   "check",
 	Short: "Validate pg_hba.conf for syntax errors",
-	Long:  "Queries pg_hba_file_rules to detect any lines PostgreSQL could not parse. Exit 0 if OK, 1 if errors found.",
+	Long:  "Queries pg_hba_file_rules to detect any lines PostgreSQL could not parse. With --file and no connection, validates the file offline instead (same checks PostgreSQL makes when loading it: types, keywords, addresses, methods and their options). Exit 0 if OK, 1 if errors found.",
 	RunE:  runCheck,
 }
 
@@ -22,8 +22,11 @@ func init() {
 
 func runCheck(cmd *cobra.Command, _ []string) error {
 	conn := connString()
+	if (conn == "" && filePath() != "") || filterMode() {
+		return runCheckOffline(filePath())
+	}
 	if conn == "" {
-		return fmt.Errorf("no connection: set DATABASE_URL or use --conn")
+		return fmt.Errorf("no connection: set DATABASE_URL or use --conn (or pass a file to validate offline with --file)")
 	}
 
 	ctx := context.Background()
@@ -50,3 +53,23 @@ func runCheck(cmd *cobra.Command, _ []string) error {
 	// Exit 1 will be set by main when we return a non-nil error. So we need to return an error.
 	return fmt.Errorf("%d syntax error(s) found", len(errs))
 }
+
+// runCheckOffline validates path and the files it includes without a server.
+func runCheckOffline(path string) error {
+	cfg, err := loadConfig(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	path = cfg.Root.Path
+	diags := cfg.Validate()
+	if len(diags) == 0 {
+		fmt.Fprintf(os.Stdout, "OK: no syntax errors in %s\n", path)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Error: syntax errors in %s:\n", path)
+	for _, d := range diags {
+		fmt.Fprintf(os.Stderr, "  %s\n    %s\n", d, d.Text)
+	}
+	return fmt.Errorf("%d syntax error(s) found", len(diags))
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/hrodrig/hbactl/internal/diff"
+	"github.com/hrodrig/hbactl/internal/hba"
+	"github.com/spf13/cobra"
+)
+
+var editReload bool
+
+var editCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Edit pg_hba.conf in $EDITOR, with validation, diff and backup",
+	Long:  "Opens a copy of pg_hba.conf in $VISUAL or $EDITOR (default vi). On save, the copy is checked like 'hbactl check -f' does; if PostgreSQL would reject a line, the errors are shown and the editor is reopened. The changes are then shown as a diff and, once confirmed, the file is backed up and atomically replaced. With a connection, offers to reload PostgreSQL afterwards (--reload reloads without asking). Both locks are held while editing.",
+	Args:  cobra.NoArgs,
+	RunE:  runEdit,
+}
+
+func init() {
+	rootCmd.AddCommand(editCmd)
+	editCmd.Flags().BoolVar(&editReload, "reload", false, "Reload PostgreSQL after writing without asking")
+}
+
+func runEdit(cmd *cobra.Command, _ []string) error {
+	if filterMode() {
+		return fmt.Errorf("edit needs a file: --file - is not supported")
+	}
+	if !interactive() {
+		return fmt.Errorf("edit needs an interactive terminal")
+	}
+	ctx := context.Background()
+	path, err := hbaPath(ctx)
+	if err != nil {
+		return err
+	}
+	v, err := pgVersion(ctx)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockServer(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	lock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	orig, err := hba.ParseDocument(path)
+	if err != nil {
+		return fmt.Errorf("could not read file (try running with sudo?): %w", err)
+	}
+	original := orig.Bytes()
+	tmp, err := os.CreateTemp("", "pg_hba-*.conf")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	_, err = tmp.Write(original)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// On success or when nothing changed the copy is removed; otherwise it is kept so no work is lost.
+	keep := true
+	defer func() {
+		if keep {
+			fmt.Fprintf(os.Stderr, "Your edited copy is kept in %s\n", tmpPath)
+		} else {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	var edited []byte
+	for {
+		if err := runEditor(tmpPath); err != nil {
+			return err
+		}
+		if edited, err = os.ReadFile(tmpPath); err != nil {
+			return err
+		}
+		if bytes.Equal(edited, original) {
+			keep = false
+			fmt.Fprintln(os.Stdout, "No changes.")
+			return nil
+		}
+		doc, err := hba.ReadDocument(bytes.NewReader(edited), path)
+		if err != nil {
+			return err
+		}
+		doc.Version = v
+		if diags := doc.Validate(); len(diags) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: PostgreSQL would reject the edited file (target version %s):\n", v)
+			for _, d := range diags {
+				fmt.Fprintf(os.Stderr, "  %s\n    %s\n", d, d.Text)
+			}
+			if confirm("Edit again?") {
+				continue
+			}
+			return fmt.Errorf("%d error(s) in the edited file; nothing written", len(diags))
+		}
+		fmt.Fprint(os.Stdout, diff.Unified(path, path+" (edited)", string(original), string(edited)))
+		if confirm("Write these changes to " + path + "?") {
+			break
+		}
+		if !confirm("Edit again?") {
+			return fmt.Errorf("nothing written")
+		}
+	}
+
+	if err := orig.CheckUnchanged(); err != nil {
+		return fmt.Errorf("%w; nothing written", err)
+	}
+	if err := flushJournal(backupAndWrite(path, func(p string) error { return hba.WriteFileAtomic(p, edited, 0600) })); err != nil {
+		return err
+	}
+	keep = false
+	fmt.Fprintf(os.Stdout, "Success: %s updated.\n", path)
+	return offerReload(ctx, editReload)
+}
+
+// runEditor opens path in $VISUAL, $EDITOR or the platform's default editor and waits for it. The
+// variable may hold arguments too (e.g. "code --wait").
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	var c *exec.Cmd
+	switch {
+	case runtime.GOOS == "windows" && editor == "":
+		c = exec.Command("notepad", path)
+	case runtime.GOOS == "windows":
+		c = exec.Command("cmd", "/c", editor+" "+path)
+	default:
+		if editor == "" {
+			editor = "vi"
+		}
+		c = exec.Command("/bin/sh", "-c", editor+` "$1"`, "sh", path)
+	}
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("editor %q failed: %w", editor, err)
+	}
+	return nil
+}
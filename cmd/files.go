@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hrodrig/hbactl/internal/hba"
+	"github.com/spf13/cobra"
+)
+
+var filesDryRun bool
+
+var filesCmd = &cobra.Command{
+	Use:   "files",
+	Short: "List and edit files referenced with @file in database/user columns",
+	Long:  "pg_hba.conf can pull database or user names from a file with @file (relative to the directory of the file holding the rule). These commands list those files and add or remove names in them, with a backup before every edit.",
+}
+
+var filesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List @file references, the rules using them and the names they contain",
+	Args:  cobra.NoArgs,
+	RunE:  runFilesList,
+}
+
+var filesAddCmd = &cobra.Command{
+	Use:   "add @FILE NAME...",
+	Short: "Add names to a referenced file",
+	Long:  "Appends each name on its own line. FILE is the entry as written in pg_hba.conf (e.g. @admins.txt) or the path of a referenced file (see 'hbactl files list'); other files are refused. Creates a backup before writing. Run 'hbactl reload' to apply changes.",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  runFilesEdit,
+}
+
+var filesRemoveCmd = &cobra.Command{
+	Use:   "remove @FILE NAME...",
+	Short: "Remove names from a referenced file",
+	Long:  "Removes each name wherever it is listed; other names and comments on the same line are kept. FILE is the entry as written in pg_hba.conf (e.g. @admins.txt) or the path of a referenced file (see 'hbactl files list'); other files are refused. Creates a backup before writing. Run 'hbactl reload' to apply changes.",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  runFilesEdit,
+}
+
+func init() {
+	rootCmd.AddCommand(filesCmd)
+	filesCmd.AddCommand(filesListCmd, filesAddCmd, filesRemoveCmd)
+	for _, c := range []*cobra.Command{filesAddCmd, filesRemoveCmd} {
+		c.Flags().BoolVar(&filesDryRun, "dry-run", false, "Print the changes without writing or creating backup")
+	}
+}
+
+func runFilesList(cmd *cobra.Command, _ []string) error {
+	path, err := hbaPath(context.Background())
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	refs := cfg.References()
+	if len(refs) == 0 {
+		fmt.Fprintf(os.Stdout, "No @file references in %s\n", path)
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTRY\tPATH\tRULES\tNAMES")
+	fmt.Fprintln(tw, "-----\t----\t-----\t-----")
+	for _, ref := range refs {
+		idx := make([]string, len(ref.Rules))
+		for i, n := range ref.Rules {
+			idx[i] = fmt.Sprintf("#%d", n)
+		}
+		names := "(missing)"
+		if f, err := cfg.NameFile(ref.Path); err == nil {
+			names = strings.Join(f.Names(), ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", ref.Written, ref.Path, strings.Join(idx, ","), names)
+	}
+	tw.Flush()
+	return nil
+}
+
+func runFilesEdit(cmd *cobra.Command, args []string) error {
+	adding := cmd.Name() == "add"
+	path, err := hbaPath(context.Background())
+	if err != nil {
+		return err
+	}
+	if !filesDryRun {
+		unlock, err := lockServer(context.Background())
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		lock, err := lockFile(path)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+	return retryOnConflict(func() error { return editNameFile(path, adding, args) })
+}
+
+// referencedFile returns the path of the file named by arg, the entry as written in a rule (e.g.
+// @admins.txt) or its path. Only files some rule references are accepted: hbactl often runs with
+// sudo, and must not become a way to append lines to any file.
+func referencedFile(cfg *hba.Config, arg string) (string, error) {
+	abs := func(p string) string {
+		if a, err := filepath.Abs(p); err == nil {
+			return a
+		}
+		return filepath.Clean(p)
+	}
+	for _, ref := range cfg.References() {
+		if ref.Written == arg || (!strings.HasPrefix(arg, "@") && abs(ref.Path) == abs(arg)) {
+			return ref.Path, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not referenced by any rule; run 'hbactl files list' to see the files that can be edited", arg)
+}
+
+// editNameFile adds or removes names (args[1:]) in the file referenced as args[0] and saves it.
+func editNameFile(path string, adding bool, args []string) error {
+	cfg, err := loadConfig(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	target, err := referencedFile(cfg, args[0])
+	if err != nil {
+		return err
+	}
+	f, err := cfg.NameFile(target)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", target, err)
+	}
+
+	var changed []string
+	for _, name := range args[1:] {
+		switch {
+		case adding && f.Add(name):
+			changed = append(changed, name)
+		case adding:
+			fmt.Fprintf(os.Stderr, "Warning: %q is already listed in %s\n", name, target)
+		case f.Remove(name):
+			changed = append(changed, name)
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: %q is not listed in %s\n", name, target)
+		}
+	}
+	verb := "remove"
+	if adding {
+		verb = "add"
+	}
+	if len(changed) == 0 {
+		return fmt.Errorf("nothing to %s in %s", verb, target)
+	}
+	if filesDryRun {
+		fmt.Fprintf(os.Stdout, "dry-run: would %s %s in %s; resulting names: %s\n", verb, strings.Join(changed, ", "), target, strings.Join(f.Names(), ","))
+		return nil
+	}
+	if err := cfg.CheckUnchanged(); err != nil {
+		return err
+	}
+	if err := flushJournal(backupAndWrite(target, f.WriteFile)); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Success: %s updated (%s %s). Run 'hbactl reload' to apply changes.\n", target, verb, strings.Join(changed, ", "))
+	return nil
+}
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hrodrig/hbactl/internal/journal"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyLimit int
+	historyUser  string
+	historySince string
+	historyJSON  bool
+)
+
+// journalChanges are the changes made by this run not yet written to the journal (see flushJournal).
+var journalChanges []journal.FileChange
+
+// journalUndoes are the IDs of the journal entries this run reverted (hbactl undo).
+var journalUndoes []string
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the journal of changes made with hbactl",
+	Long:  "Every command that changes pg_hba.conf (or a file it includes or references) appends an entry to the journal (see --journal): when, by whom (OS user and SUDO_USER), the command line, --reason, and the rules added and removed. Newest entries are shown first.",
+	Args:  cobra.NoArgs,
+	RunE:  runHistory,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 20, "Show at most N entries (0: all)")
+	historyCmd.Flags().StringVar(&historyUser, "user", "", "Only entries by this OS user or SUDO_USER")
+	historyCmd.Flags().StringVar(&historySince, "since", "", "Only entries from this time on (e.g. '2024-05-01 14:30', RFC 3339)")
+	historyCmd.Flags().BoolVar(&historyJSON, "json", false, "Print the entries as JSON lines, as stored")
+}
+
+// recordChange records that path was changed from before (saved to backup) for the journal.
+func recordChange(path string, before []byte, backup string) {
+	after, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if abs, err := filepath.Abs(backup); err == nil {
+		backup = abs
+	}
+	journalChanges = append(journalChanges, journal.Change(path, before, after, backup))
+}
+
+// journalPath returns the journal file: --journal, or pg_hba.conf.journal next to the file. It is
+// empty with --journal none.
+func journalPath() (string, error) {
+	switch journalFlag {
+	case "none":
+		return "", nil
+	case "":
+	default:
+		return journalFlag, nil
+	}
+	root := filePath()
+	if root == "" {
+		root = hbaFilePathFromServer
+	}
+	if root == "" || root == "-" {
+		return "", fmt.Errorf("no pg_hba.conf path to put the journal next to; use --journal")
+	}
+	return root + ".journal", nil
+}
+
+// flushJournal writes the journal entry for the changes recorded so far (see writeJournal). Commands
+// call it while they still hold the edit locks, so that entries are appended in the order the files
+// were written and their hashes chain for undo. err is the result of the edit: it is returned as is,
+// or, if the edit succeeded, replaced by the failure to record it.
+func flushJournal(err error) error {
+	jerr := writeJournal()
+	if jerr == nil {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not record the changes in the journal: %v\n", jerr)
+		return err
+	}
+	return fmt.Errorf("the changes were made but could not be recorded in the journal: %w", jerr)
+}
+
+// writeJournal appends an entry for the changes made by this run, if any, to the journal file and,
+// with --journal-syslog, to syslog.
+func writeJournal() error {
+	if len(journalChanges) == 0 {
+		return nil
+	}
+	command := append([]string{"hbactl"}, os.Args[1:]...)
+	e := journal.NewEntry(command, reason)
+	e.Files = journalChanges
+	e.Undoes = journalUndoes
+	journalChanges, journalUndoes = nil, nil
+
+	var errs []error
+	path, err := journalPath()
+	if err != nil {
+		errs = append(errs, err)
+	} else if path != "" {
+		if err := journal.Append(path, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if journalSyslog {
+		if err := journal.Syslog(e); err != nil {
+			errs = append(errs, fmt.Errorf("syslog: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readJournal returns the entries of the journal for the configured pg_hba.conf.
+func readJournal() (string, []journal.Entry, error) {
+	if filterMode() {
+		return "", nil, fmt.Errorf("the journal needs a file: --file - is not supported")
+	}
+	if _, err := hbaPath(context.Background()); err != nil && journalFlag == "" {
+		return "", nil, err
+	}
+	path, err := journalPath()
+	if err != nil {
+		return "", nil, err
+	}
+	if path == "" {
+		return "", nil, fmt.Errorf("the journal is disabled (--journal none)")
+	}
+	entries, err := journal.Read(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read the journal: %w", err)
+	}
+	return path, entries, nil
+}
+
+func runHistory(cmd *cobra.Command, _ []string) error {
+	path, entries, err := readJournal()
+	if err != nil {
+		return err
+	}
+	var since time.Time
+	if historySince != "" {
+		if since, err = parseTime(historySince); err != nil {
+			return err
+		}
+	}
+	var shown []journal.Entry
+	for i := len(entries) - 1; i >= 0 && (historyLimit <= 0 || len(shown) < historyLimit); i-- {
+		e := entries[i]
+		if historyUser != "" && e.User != historyUser && e.SudoUser != historyUser {
+			continue
+		}
+		if e.Time.Before(since) {
+			continue
+		}
+		shown = append(shown, e)
+	}
+
+	if historyJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range shown {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(shown) == 0 {
+		fmt.Fprintf(os.Stdout, "No entries in %s\n", path)
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tUSER\tCHANGES\tREASON\tCOMMAND")
+	fmt.Fprintln(tw, "--\t----\t----\t-------\t------\t-------")
+	for _, e := range shown {
+		who := e.User
+		if e.SudoUser != "" {
+			who = fmt.Sprintf("%s (sudo: %s)", e.User, e.SudoUser)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.ID[:min(8, len(e.ID))], e.Time.Local().Format(time.DateTime), who, changeSummary(e), e.Reason, shellJoin(e.Command))
+	}
+	tw.Flush()
+	return nil
+}
+
+// changeSummary describes the changes of e, e.g. "+1 -0 pg_hba.conf" or "undo of 2 change(s): ...".
+func changeSummary(e journal.Entry) string {
+	var parts []string
+	for _, c := range e.Files {
+		parts = append(parts, fmt.Sprintf("+%d -%d %s", len(c.Added), len(c.Removed), filepath.Base(c.Path)))
+	}
+	s := strings.Join(parts, ", ")
+	if n := len(e.Undoes); n > 0 {
+		s = fmt.Sprintf("undo of %d change(s): %s", n, s)
+	}
+	return s
+}
+
+// shellJoin joins args into a command line, quoting those a shell would split or expand.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
@@ -7,12 +7,12 @@ import (
 
 	"github.com/hrodrig/hbactl/internal/cli"
 	"github.com/hrodrig/hbactl/internal/hba"
-	"github.com/hrodrig/hbactl/internal/pg"
 	"github.com/spf13/cobra"
 )
 
 var listSort string
 var listGroupBy string
+var listStrict bool
 
 var listCmd = &cobra.Command{
 	Use:   "list",
@@ -25,32 +25,29 @@ func init() {
 	rootCmd.AddCommand(listCmd)
 	listCmd.Flags().StringVar(&listSort, "sort", "", "Sort by column: type, database, user, address, method")
 	listCmd.Flags().StringVar(&listGroupBy, "group-by", "", "Print visual separators by column (e.g. user); implies --sort by that column if not set")
+	listCmd.Flags().BoolVar(&listStrict, "strict", false, "Fail (exit 1) if any line could not be parsed instead of warning")
 }
 
 func runList(cmd *cobra.Command, _ []string) error {
-	path := filePath()
-	if path == "" {
-		conn := connString()
-		if conn == "" {
-			return fmt.Errorf("no connection: set DATABASE_URL or use --conn (or pass path with --file)")
-		}
-		ctx := context.Background()
-		client, err := pg.NewClient(ctx, conn)
-		if err != nil {
-			return fmt.Errorf("could not connect to PostgreSQL: %w", err)
-		}
-		defer client.Close()
-		p, err := client.HBAFilePath(ctx)
-		if err != nil {
-			return fmt.Errorf("could not locate pg_hba.conf. Is PostgreSQL running? %w", err)
-		}
-		path = p
+	path, err := hbaPath(context.Background())
+	if err != nil {
+		return err
 	}
 
-	rwl, err := hba.ParseFileWithLineNumbers(path)
+	cfg, err := loadConfig(context.Background(), path)
 	if err != nil {
-		return fmt.Errorf("could not read file (try running with sudo?): %w", err)
+		return err
 	}
+	path = cfg.Root.Path
+	if diags := cfg.Diagnostics(); len(diags) > 0 {
+		for _, d := range diags {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", d, d.Text)
+		}
+		if listStrict {
+			return fmt.Errorf("%d line(s) could not be parsed", len(diags))
+		}
+	}
+	rwl := cfg.Rules()
 
 	sortCol := listSort
 	if listGroupBy != "" {
@@ -68,7 +65,11 @@ func runList(cmd *cobra.Command, _ []string) error {
 		hba.SortRulesWithLine(rwl, sortCol)
 	}
 
-	fmt.Printf("File: %s (%d rule(s))\n\n", path, len(rwl))
+	if n := len(cfg.Docs) - 1; n > 0 {
+		fmt.Printf("File: %s (%d rule(s), %d included file(s))\n\n", path, len(rwl), n)
+	} else {
+		fmt.Printf("File: %s (%d rule(s))\n\n", path, len(rwl))
+	}
 	if listGroupBy == "user" {
 		cli.WriteRulesTableGroupedByUser(os.Stdout, rwl)
 	} else {
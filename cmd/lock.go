@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hrodrig/hbactl/internal/pg"
+	"github.com/spf13/cobra"
+)
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Inspect the locks that serialize edits",
+	Long:  "Every command that writes a file (add, remove, update, move, apply-batch, edit, files add/remove, backups restore, undo), and reload, takes an advisory lock on the server (pg_advisory_lock) when a connection is configured, so operators managing the same cluster from different hosts take turns; commands that write a file also lock pg_hba.conf.lock next to it. Advisory locks are per database: connect to the same database (e.g. postgres) from every host.",
+}
+
+var lockStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show which sessions hold or wait for the server lock",
+	Args:  cobra.NoArgs,
+	RunE:  runLockStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+	lockCmd.AddCommand(lockStatusCmd)
+}
+
+func runLockStatus(cmd *cobra.Command, _ []string) error {
+	conn := connString()
+	if conn == "" {
+		return fmt.Errorf("no connection: set DATABASE_URL or use --conn")
+	}
+
+	ctx := context.Background()
+	client, err := pg.NewClient(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("could not connect to PostgreSQL: %w", err)
+	}
+	defer client.Close()
+
+	holders, err := client.LockHolders(ctx)
+	if err != nil {
+		return fmt.Errorf("could not read pg_locks: %w", err)
+	}
+	if len(holders) == 0 {
+		fmt.Fprintln(os.Stdout, "Server lock is free.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATE\tPID\tUSER\tAPPLICATION\tCLIENT\tCONNECTED")
+	fmt.Fprintln(tw, "-----\t---\t----\t-----------\t------\t---------")
+	for _, h := range holders {
+		state := "waiting"
+		if h.Granted {
+			state = "held"
+		}
+		client := h.ClientAddr
+		if client == "" {
+			client = "local"
+		}
+		since := ""
+		if !h.BackendStart.IsZero() {
+			since = h.BackendStart.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", state, h.PID, h.User, h.Application, client, since)
+	}
+	tw.Flush()
+	return nil
+}
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hrodrig/hbactl/internal/hba"
+	"github.com/hrodrig/hbactl/internal/pg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	moveIndex   int
+	moveTo      int
+	moveBefore  int
+	moveAfter   int
+	moveClients []string
+	moveDryRun  bool
+)
+
+var moveCmd = &cobra.Command{
+	Use:   "move",
+	Short: "Move a rule to another position",
+	Long:  "Moves rule --index N, together with the comment lines directly above it, to where rule --to M is (before it when moving up, after it when moving down), or just --before / --after rule M. Rules are numbered as in 'hbactl list', and can only be moved within the file they live in. Since the first matching rule wins, moving a rule can change which rule a client gets: the order before and after is shown, and for every known connection (the server's current sessions when connected, and each --client) hbactl warns if it would now match another rule. Creates a backup before writing. Use --dry-run to preview. Run 'hbactl reload' after to apply changes.",
+	Args:  cobra.NoArgs,
+	RunE:  runMove,
+}
+
+func init() {
+	rootCmd.AddCommand(moveCmd)
+	moveCmd.Flags().IntVar(&moveIndex, "index", 0, "1-based index of the rule to move (see first column of 'hbactl list')")
+	moveCmd.Flags().IntVar(&moveTo, "to", 0, "Move to where rule M is: before it when moving up, after it when moving down")
+	moveCmd.Flags().IntVar(&moveBefore, "before", 0, "Move just before rule M (and the comment lines above it)")
+	moveCmd.Flags().IntVar(&moveAfter, "after", 0, "Move just after rule M")
+	moveCmd.Flags().StringArrayVar(&moveClients, "client", nil, "A connection to check, as 'user=NAME db=NAME [addr=IP] [ssl=on|off] [gss=on|off]' (no addr: Unix-domain socket); repeat for several")
+	moveCmd.Flags().BoolVar(&moveDryRun, "dry-run", false, "Show the order before and after without writing or creating backup")
+	_ = moveCmd.MarkFlagRequired("index")
+}
+
+func runMove(cmd *cobra.Command, _ []string) error {
+	if moveIndex < 1 {
+		return fmt.Errorf("--index must be >= 1")
+	}
+	set := 0
+	for _, name := range []string{"to", "before", "after"} {
+		if cmd.Flags().Changed(name) {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("specify exactly one of --to M, --before M or --after M")
+	}
+	if moveTo < 0 || moveBefore < 0 || moveAfter < 0 || moveTo+moveBefore+moveAfter == 0 {
+		return fmt.Errorf("--to, --before and --after must be >= 1")
+	}
+	ctx := context.Background()
+	conns, err := knownConnections(ctx)
+	if err != nil {
+		return err
+	}
+
+	path, err := hbaPath(ctx)
+	if err != nil {
+		return err
+	}
+	if !moveDryRun {
+		unlock, err := lockServer(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		lock, err := lockFile(path)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+	// move only takes indices.
+	return retryUnlessIndexed(true, func() error { return moveRule(ctx, path, conns) })
+}
+
+// knownConnections returns the connections given with --client and, with a connection to the
+// server, its current sessions.
+func knownConnections(ctx context.Context) ([]hba.Connection, error) {
+	var conns []hba.Connection
+	for _, s := range moveClients {
+		c, err := hba.ParseConnection(s)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, c)
+	}
+	conn := connString()
+	if conn == "" {
+		return conns, nil
+	}
+	client, err := pg.NewClient(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to PostgreSQL: %w", err)
+	}
+	defer client.Close()
+	sessions, err := client.Sessions(ctx)
+	if err != nil {
+		// The move does not depend on it: only the check of the sessions is skipped.
+		fmt.Fprintf(os.Stderr, "Warning: could not list the server's sessions: %v\n", err)
+		return conns, nil
+	}
+	for _, s := range sessions {
+		ssl := s.SSL
+		c := hba.Connection{User: s.User, Database: s.Database, SSL: &ssl, GSSEnc: s.GSSEnc}
+		if s.ClientAddr != "" {
+			if c.Addr = net.ParseIP(s.ClientAddr); c.Addr == nil {
+				continue
+			}
+		}
+		conns = append(conns, c)
+	}
+	return conns, nil
+}
+
+// moveRule reads the configuration at path, moves the rule, shows the order before and after,
+// warns about connections whose matching rule changes, and saves it.
+func moveRule(ctx context.Context, path string, conns []hba.Connection) error {
+	cfg, err := loadConfig(ctx, path)
+	if err != nil {
+		return err
+	}
+	before := cfg.Rules()
+	rule := func(index int) (hba.RuleWithLine, error) {
+		x, ok := cfg.Rule(index)
+		if !ok {
+			return x, fmt.Errorf("no rule at index %d (file has %d rule(s)); run 'hbactl list' to see indices", index, len(before))
+		}
+		return x, nil
+	}
+	x, err := rule(moveIndex)
+	if err != nil {
+		return err
+	}
+	targetIndex, after := moveBefore, false
+	switch {
+	case moveAfter != 0:
+		targetIndex, after = moveAfter, true
+	case moveTo != 0:
+		targetIndex, after = moveTo, moveTo > moveIndex
+	}
+	target, err := rule(targetIndex)
+	if err != nil {
+		return err
+	}
+	// Nodes identify the rules across the move.
+	nodes := make(map[*hba.Node]hba.RuleWithLine, len(before))
+	for _, r := range before {
+		nodes[cfg.Node(r)] = r
+	}
+	moved := cfg.Node(x)
+	if err := cfg.MoveRule(x, target, after); err != nil {
+		return err
+	}
+	if len(cfg.Changed()) == 0 {
+		fmt.Fprintf(msgOut(), "Rule #%d is already there; nothing to do.\n", moveIndex)
+		if filterMode() && !moveDryRun {
+			return saveConfig(cfg) // pass the file through unchanged
+		}
+		return nil
+	}
+	afterRules := cfg.Rules()
+	now, _ := cfg.Find(moved)
+
+	out := msgOut()
+	rel := "before"
+	if after {
+		rel = "after"
+	}
+	prefix := "Moving"
+	if moveDryRun {
+		prefix = "dry-run: would move"
+	}
+	fmt.Fprintf(out, "%s rule #%d (%s) %s rule #%d (%s); it becomes rule #%d (%s).\n", prefix, x.Index, ruleLocation(cfg, x), rel, target.Index, ruleLocation(cfg, target), now.Index, ruleLocation(cfg, now))
+	lo, hi := min(x.Index, now.Index), max(x.Index, now.Index)
+	printOrder(out, cfg, "Before:", before[lo-1:hi], nil)
+	printOrder(out, cfg, "After:", afterRules[lo-1:hi], nodes)
+
+	for _, c := range conns {
+		was, wasCertain, wasOK := hba.FirstMatch(before, c)
+		is, isCertain, isOK := hba.FirstMatch(afterRules, c)
+		const depends = "depending on role membership, samehost/samenet, host names or SSL"
+		switch {
+		case wasOK != isOK || (wasOK && nodes[cfg.Node(is)].Index != was.Index):
+			note := ""
+			if !wasCertain || !isCertain {
+				note = " (not certain: an earlier rule may match, " + depends + ")"
+			}
+			fmt.Fprintf(os.Stderr, "Warning: connection %s would now match %s; before the move it matched %s%s\n", c, describeMatch(is, isOK), describeMatch(was, wasOK), note)
+		default:
+			// The rule that surely matches is the same, but one that may match first can differ.
+			wasC, isC := hba.Candidates(before, c), hba.Candidates(afterRules, c)
+			if len(wasC) > 0 && len(isC) > 0 && nodes[cfg.Node(isC[0])].Index != wasC[0].Index {
+				fmt.Fprintf(os.Stderr, "Warning: connection %s may now match %s instead of %s, %s\n", c, describeMatch(isC[0], true), describeMatch(wasC[0], true), depends)
+			}
+		}
+	}
+
+	if moveDryRun {
+		return nil
+	}
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Success: rule #%d moved to #%d in %s. Run 'hbactl reload' to apply changes.\n", x.Index, now.Index, now.File)
+	return nil
+}
+
+// printOrder prints rules as a table under title. With was set (node to the rule before the move),
+// rules that changed index show their old one.
+func printOrder(out io.Writer, cfg *hba.Config, title string, rules []hba.RuleWithLine, was map[*hba.Node]hba.RuleWithLine) {
+	fmt.Fprintln(out, title)
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, r := range rules {
+		note := ""
+		if old, ok := was[cfg.Node(r)]; ok && old.Index != r.Index {
+			note = fmt.Sprintf("  (was #%d)", old.Index)
+		}
+		fmt.Fprintf(tw, "  #%d\t%s\t%s%s\n", r.Index, ruleLocation(cfg, r), strings.Join(strings.Fields(r.Rule.Line()), " "), note)
+	}
+	tw.Flush()
+}
+
+// describeMatch describes the rule a connection matches, or that none does.
+func describeMatch(x hba.RuleWithLine, ok bool) string {
+	if !ok {
+		return "no rule (rejected)"
+	}
+	return fmt.Sprintf("rule #%d (%s)", x.Index, strings.Join(strings.Fields(x.Rule.Line()), " "))
+}
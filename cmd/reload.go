@@ -12,7 +12,7 @@ import (
 var reloadCmd = &cobra.Command{
 	Use:   "reload",
 	Short: "Reload PostgreSQL configuration",
-	Long:  "Runs pg_reload_conf() so the server picks up the current pg_hba.conf without restart.",
+	Long:  "Runs pg_reload_conf() so the server picks up the current pg_hba.conf without restart. Waits for the server lock first, so it does not reload in the middle of another operator's edit (see 'hbactl lock status').",
 	RunE:  runReload,
 }
 
@@ -33,10 +33,39 @@ func runReload(cmd *cobra.Command, _ []string) error {
 	}
 	defer client.Close()
 
+	// Do not reload while another operator is in the middle of an edit.
+	lock, err := client.AcquireAdvisoryLock(ctx, lockTimeout)
+	if err != nil {
+		return serverLockError(err)
+	}
+	defer lock.Release(ctx)
+
+	return reloadConf(ctx, client)
+}
+
+// offerReload reloads PostgreSQL after a change, when a connection is configured: right away if
+// always, else if the user agrees. The caller holds the server lock.
+func offerReload(ctx context.Context, always bool) error {
+	if connString() == "" {
+		return nil
+	}
+	if !always && !confirm("Reload PostgreSQL now?") {
+		fmt.Fprintln(os.Stdout, "Run 'hbactl reload' to apply changes.")
+		return nil
+	}
+	client, err := pg.NewClient(ctx, connString())
+	if err != nil {
+		return fmt.Errorf("could not connect to PostgreSQL: %w", err)
+	}
+	defer client.Close()
+	return reloadConf(ctx, client)
+}
+
+// reloadConf runs pg_reload_conf() on client. The caller holds the server lock.
+func reloadConf(ctx context.Context, client *pg.Client) error {
 	if err := client.ReloadConf(ctx); err != nil {
 		return fmt.Errorf("reload failed: %w", err)
 	}
-
 	fmt.Fprintln(os.Stdout, "Success: configuration reloaded.")
 	return nil
 }
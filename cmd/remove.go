@@ -7,7 +7,6 @@ import (
 	"strings"
 
 	"github.com/hrodrig/hbactl/internal/hba"
-	"github.com/hrodrig/hbactl/internal/pg"
 	"github.com/spf13/cobra"
 )
 
@@ -16,13 +15,14 @@ var (
 	removeUser   string
 	removeDB     string
 	removeAddr   string
+	removeStrip  bool
 	removeDryRun bool
 )
 
 var removeCmd = &cobra.Command{
 	Use:   "remove",
 	Short: "Remove rule(s) from pg_hba.conf by index or by criteria",
-	Long:  "Removes one rule by --index, or all matching rules by --user (optional --db) or --addr. Creates a backup before editing. Use --dry-run to preview. Run 'hbactl reload' after to apply changes.",
+	Long:  "Removes one rule by --index, or all matching rules by --user (optional --db) or --addr. A rule matches --user/--db when the user (or database) appears in its comma-separated list; use --strip to only take the user out of such lists. Creates a backup before editing. Use --dry-run to preview. Run 'hbactl reload' after to apply changes.",
 	RunE:  runRemove,
 }
 
@@ -32,6 +32,7 @@ func init() {
 	removeCmd.Flags().StringVar(&removeUser, "user", "", "Remove all rules for this user; combine with --db to limit by database")
 	removeCmd.Flags().StringVar(&removeDB, "db", "", "When used with --user, only remove rules for this database")
 	removeCmd.Flags().StringVar(&removeAddr, "addr", "", "Remove all rules matching this address (e.g. 10.0.1.7 or 10.0.1.7/32)")
+	removeCmd.Flags().BoolVar(&removeStrip, "strip", false, "With --user: only remove the user from rules that list several users (e.g. alice,bob → bob); rules listing only that user are still removed")
 	removeCmd.Flags().BoolVar(&removeDryRun, "dry-run", false, "Print the rule(s) that would be removed without writing or creating backup")
 }
 
@@ -49,33 +50,46 @@ func runRemove(cmd *cobra.Command, _ []string) error {
 	if !byIndex && !byUser && !byAddr {
 		return fmt.Errorf("specify --index N, or --user <name> [--db <name>], or --addr <address>")
 	}
+	if removeStrip && !byUser {
+		return fmt.Errorf("--strip requires --user")
+	}
 	if byIndex && removeIndex < 1 {
 		return fmt.Errorf("--index must be >= 1")
 	}
 
-	path := filePath()
-	if path == "" {
-		conn := connString()
-		if conn == "" {
-			return fmt.Errorf("no connection: set DATABASE_URL or use --conn (or pass path with --file)")
-		}
-		ctx := context.Background()
-		client, err := pg.NewClient(ctx, conn)
+	path, err := hbaPath(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if !removeDryRun {
+		unlock, err := lockServer(context.Background())
 		if err != nil {
-			return fmt.Errorf("could not connect to PostgreSQL: %w", err)
+			return err
 		}
-		defer client.Close()
-		p, err := client.HBAFilePath(ctx)
+		defer unlock()
+		lock, err := lockFile(path)
 		if err != nil {
-			return fmt.Errorf("could not locate pg_hba.conf. Is PostgreSQL running? %w", err)
+			return err
 		}
-		path = p
+		defer lock.Release()
 	}
+	return retryUnlessIndexed(byIndex, func() error { return removeRules(path) })
+}
+
+// removeRules reads the configuration at path, removes (or strips) the rules selected by the flags
+// and saves it.
+func removeRules(path string) error {
+	byIndex := removeIndex >= 1
+	byUser := strings.TrimSpace(removeUser) != ""
+	byAddr := strings.TrimSpace(removeAddr) != ""
 
-	rwl, err := hba.ParseFileWithLineNumbers(path)
+	cfg, err := loadConfig(context.Background(), path)
 	if err != nil {
-		return fmt.Errorf("could not read file (try running with sudo?): %w", err)
+		return err
 	}
+	path = cfg.Root.Path
+	rwl := cfg.Rules()
 
 	var toRemove []hba.RuleWithLine
 	if byIndex {
@@ -95,7 +109,7 @@ func runRemove(cmd *cobra.Command, _ []string) error {
 		for i := range rwl {
 			r := rwl[i].Rule
 			matches := false
-			if byUser && r.MatchesUser(user, db) {
+			if byUser && rwl[i].MatchesUser(user, db) {
 				matches = true
 			}
 			if byAddr && r.MatchesAddress(addr) {
@@ -119,38 +133,68 @@ func runRemove(cmd *cobra.Command, _ []string) error {
 		}
 	}
 
-	if removeDryRun {
-		fmt.Fprintf(os.Stdout, "dry-run: would remove %d rule(s) from %s:\n", len(toRemove), path)
+	// With --strip, rules that still list other users are rewritten instead of removed.
+	type strippedRule struct {
+		from hba.RuleWithLine
+		to   hba.Rule
+	}
+	var toStrip []strippedRule
+	if removeStrip {
+		user := strings.TrimSpace(removeUser)
+		kept := toRemove[:0]
 		for _, x := range toRemove {
-			fmt.Fprintf(os.Stdout, "  #%d (line %d): %s\n", x.Index, x.LineNo, x.Rule.Line())
+			if r, ok := x.Rule.WithoutUser(user); ok {
+				if r.User == x.Rule.User {
+					fmt.Fprintf(os.Stderr, "Warning: rule #%d matches user %q only through a regex or @file entry (%s); left unchanged\n", x.Index, user, r.User)
+					continue
+				}
+				if r.MatchesUser(user, "") {
+					fmt.Fprintf(os.Stderr, "Warning: rule #%d still matches user %q through a regex entry (%s)\n", x.Index, user, r.User)
+				}
+				toStrip = append(toStrip, strippedRule{from: x, to: r})
+			} else {
+				kept = append(kept, x)
+			}
 		}
-		return nil
+		toRemove = kept
 	}
 
-	backupPath, err := hba.Backup(path)
-	if err != nil {
-		if os.IsPermission(err) {
-			return fmt.Errorf("insufficient permissions to write to pg_hba.conf. Try running with sudo")
+	if removeDryRun {
+		if len(toStrip) > 0 {
+			fmt.Fprintf(msgOut(), "dry-run: would strip user %q from %d rule(s) in %s:\n", strings.TrimSpace(removeUser), len(toStrip), path)
+			for _, s := range toStrip {
+				fmt.Fprintf(msgOut(), "  #%d (%s): %s\n  → %s\n", s.from.Index, ruleLocation(cfg, s.from), s.from.Rule.Line(), s.to.Line())
+			}
 		}
-		return fmt.Errorf("backup failed: %w", err)
+		if len(toRemove) > 0 || len(toStrip) == 0 {
+			fmt.Fprintf(msgOut(), "dry-run: would remove %d rule(s) from %s:\n", len(toRemove), path)
+			for _, x := range toRemove {
+				fmt.Fprintf(msgOut(), "  #%d (%s): %s\n", x.Index, ruleLocation(cfg, x), x.Rule.Line())
+			}
+		}
+		return nil
 	}
-	fmt.Fprintf(os.Stderr, "Backup created at: %s\n", backupPath)
 
-	lineNos := make([]int, len(toRemove))
-	for i := range toRemove {
-		lineNos[i] = toRemove[i].LineNo
-	}
-	if err := hba.RemoveLines(path, lineNos); err != nil {
-		if os.IsPermission(err) {
-			return fmt.Errorf("insufficient permissions to write to pg_hba.conf. Try running with sudo")
+	for _, s := range toStrip {
+		if err := cfg.ReplaceRule(s.from, s.to); err != nil {
+			return fmt.Errorf("failed to update rule #%d: %w", s.from.Index, err)
 		}
-		return fmt.Errorf("remove failed: %w", err)
+	}
+	cfg.Remove(toRemove)
+	if err := saveConfig(cfg); err != nil {
+		return err
 	}
 
-	if len(toRemove) == 1 {
-		fmt.Fprintf(os.Stdout, "Success: Rule #%d removed from %s. Run 'hbactl reload' to apply changes.\n", toRemove[0].Index, path)
-	} else {
-		fmt.Fprintf(os.Stdout, "Success: %d rule(s) removed from %s. Run 'hbactl reload' to apply changes.\n", len(toRemove), path)
+	if len(toStrip) > 0 {
+		fmt.Fprintf(msgOut(), "Success: user %q stripped from %d rule(s) in %s.\n", strings.TrimSpace(removeUser), len(toStrip), path)
+	}
+	switch {
+	case len(toRemove) == 1:
+		fmt.Fprintf(msgOut(), "Success: Rule #%d removed from %s. Run 'hbactl reload' to apply changes.\n", toRemove[0].Index, path)
+	case len(toRemove) > 1:
+		fmt.Fprintf(msgOut(), "Success: %d rule(s) removed from %s. Run 'hbactl reload' to apply changes.\n", len(toRemove), path)
+	default:
+		fmt.Fprintln(msgOut(), "Run 'hbactl reload' to apply changes.")
 	}
 	return nil
 }
@@ -1,13 +1,35 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/hrodrig/hbactl/internal/hba"
+	"github.com/hrodrig/hbactl/internal/pg"
 	"github.com/spf13/cobra"
 )
 
 var connStr string
 var hbaFilePath string
+var pgVersionFlag string
+var lockTimeout time.Duration
+var conflictRetries int
+var backupOpts hba.BackupOptions
+var reason string
+var journalFlag string
+var journalSyslog bool
+
+// hbaFilePathFromServer caches the path read from the server (SHOW hba_file).
+var hbaFilePathFromServer string
+
+// detectedVersion caches the version read from the server, so it is queried once per run.
+var detectedVersion *hba.Version
 
 // Version is set at build time via ldflags (e.g. -X github.com/hrodrig/hbactl/cmd.Version=v0.1.0). Default: "dev".
 var Version = "dev"
@@ -23,6 +45,16 @@ func init() {
 	rootCmd.SetVersionTemplate("hbactl {{.Version}}\n")
 	rootCmd.PersistentFlags().StringVarP(&connStr, "conn", "c", "", "PostgreSQL connection string (default: DATABASE_URL env)")
 	rootCmd.PersistentFlags().StringVarP(&hbaFilePath, "file", "f", "", "Path to pg_hba.conf (if set, list uses it and may skip connection; for multiple servers, pass path per run)")
+	rootCmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", hba.DefaultLockTimeout, "How long to wait for another hbactl run editing the same file or holding the server lock (e.g. 30s)")
+	rootCmd.PersistentFlags().IntVar(&conflictRetries, "conflict-retries", 0, "If the file is changed by someone else during an edit, re-read it and re-apply the edit up to N times (remove --index, add with an index or line, and apply-batch with indices never retry)")
+	rootCmd.PersistentFlags().StringVar(&backupOpts.Dir, "backup-dir", "", "Directory for backups (created if missing), with a subdirectory per edited file's directory; default: next to the edited file")
+	rootCmd.PersistentFlags().IntVar(&backupOpts.Keep, "backup-keep", 0, "After an edit, keep only the N newest backups of the file (0: keep all)")
+	rootCmd.PersistentFlags().DurationVar(&backupOpts.MaxAge, "backup-max-age", 0, "After an edit, remove backups older than this (e.g. 720h for 30 days; 0: keep all)")
+	rootCmd.PersistentFlags().BoolVar(&backupOpts.Compress, "backup-gzip", false, "Compress backups with gzip")
+	rootCmd.PersistentFlags().StringVar(&reason, "reason", "", "Why the change is made, recorded in the journal (e.g. a ticket number)")
+	rootCmd.PersistentFlags().StringVar(&journalFlag, "journal", "", "Journal file recording every change (default: pg_hba.conf.journal next to the file; none: do not record)")
+	rootCmd.PersistentFlags().BoolVar(&journalSyslog, "journal-syslog", false, "Also send journal entries to syslog (facility auth, tag hbactl)")
+	rootCmd.PersistentFlags().StringVar(&pgVersionFlag, "pg-version", "", "Target PostgreSQL major version (e.g. 15); default: detected from the server when connected, else latest")
 }
 
 // connString returns the connection string: flag if set, else DATABASE_URL.
@@ -38,7 +70,173 @@ func filePath() string {
 	return hbaFilePath
 }
 
-// Execute runs the root command.
+// stdinName names the file read from standard input in messages and diagnostics.
+const stdinName = "<stdin>"
+
+// filterMode reports whether --file is "-": the file is read from stdin and, when modified, written
+// to stdout (no backup, no in-place write).
+func filterMode() bool {
+	return hbaFilePath == "-"
+}
+
+// msgOut is where commands print status messages: stdout, or stderr in filter mode so that stdout
+// only carries the file.
+func msgOut() io.Writer {
+	if filterMode() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// interactive reports whether stdin is a terminal.
+func interactive() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks question on stderr and reports whether the answer is yes. Without a terminal on
+// stdin there is nobody to ask: the answer is no.
+func confirm(question string) bool {
+	if !interactive() {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// hbaPath returns the pg_hba.conf path from --file, or asks the server (SHOW hba_file).
+func hbaPath(ctx context.Context) (string, error) {
+	if path := filePath(); path != "" {
+		return path, nil
+	}
+	if hbaFilePathFromServer != "" {
+		return hbaFilePathFromServer, nil
+	}
+	conn := connString()
+	if conn == "" {
+		return "", fmt.Errorf("no connection: set DATABASE_URL or use --conn (or pass path with --file)")
+	}
+	client, err := pg.NewClient(ctx, conn)
+	if err != nil {
+		return "", fmt.Errorf("could not connect to PostgreSQL: %w", err)
+	}
+	defer client.Close()
+	path, err := client.HBAFilePath(ctx)
+	if err != nil {
+		return "", fmt.Errorf("could not locate pg_hba.conf. Is PostgreSQL running? %w", err)
+	}
+	hbaFilePathFromServer = path
+	return path, nil
+}
+
+// pgVersion returns the target PostgreSQL version: --pg-version if set, else server_version_num of
+// the server when a connection is configured, else zero (latest: all syntax accepted).
+func pgVersion(ctx context.Context) (hba.Version, error) {
+	if pgVersionFlag != "" {
+		return hba.ParseVersion(pgVersionFlag)
+	}
+	if detectedVersion != nil {
+		return *detectedVersion, nil
+	}
+	conn := connString()
+	if conn == "" {
+		return 0, nil
+	}
+	client, err := pg.NewClient(ctx, conn)
+	if err != nil {
+		return 0, fmt.Errorf("could not connect to PostgreSQL to detect its version (or pass --pg-version): %w", err)
+	}
+	defer client.Close()
+	num, err := client.ServerVersionNum(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not read server_version_num (or pass --pg-version): %w", err)
+	}
+	v := hba.VersionFromNum(num)
+	detectedVersion = &v
+	return v, nil
+}
+
+// loadConfig reads path and its includes for the target PostgreSQL version (see pgVersion).
+// A path of "-" reads standard input (includes are not followed).
+func loadConfig(ctx context.Context, path string) (*hba.Config, error) {
+	v, err := pgVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if path == "-" {
+		return hba.ReadConfig(os.Stdin, stdinName, v)
+	}
+	cfg, err := hba.LoadConfigVersion(path, v)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file (try running with sudo?): %w", err)
+	}
+	for _, doc := range cfg.Docs {
+		if fi, err := os.Stat(doc.Path); err == nil && hba.InsecureMode(fi.Mode()) {
+			fmt.Fprintf(os.Stderr, "Warning: %s has mode %04o; it may hold passwords and should not be readable by others or writable by group/others (e.g. chmod 0640)\n", doc.Path, fi.Mode().Perm())
+		}
+	}
+	return cfg, nil
+}
+
+// lockFile takes the edit lock on path (see hba.AcquireLock) for the rest of the run. It returns nil
+// in filter mode, where there is no file to protect.
+func lockFile(path string) (*hba.Lock, error) {
+	if filterMode() {
+		return nil, nil
+	}
+	l, err := hba.AcquireLock(path, lockTimeout)
+	var locked *hba.LockedError
+	if errors.As(err, &locked) {
+		return nil, fmt.Errorf("%w; try again later or raise --lock-timeout", err)
+	}
+	if err != nil {
+		if os.IsPermission(err) {
+			return nil, fmt.Errorf("insufficient permissions to lock %s. Try running with sudo", path)
+		}
+		return nil, fmt.Errorf("could not lock %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// lockServer takes the server's advisory lock (see pg.AdvisoryLockKey) when a connection is
+// configured, so that operators editing the same cluster from different hosts take turns. The
+// returned function releases it; it does nothing when no connection is configured or in filter mode.
+func lockServer(ctx context.Context) (release func(), err error) {
+	conn := connString()
+	if conn == "" || filterMode() {
+		return func() {}, nil
+	}
+	client, err := pg.NewClient(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to PostgreSQL to take the server lock: %w", err)
+	}
+	l, err := client.AcquireAdvisoryLock(ctx, lockTimeout)
+	if err != nil {
+		client.Close()
+		return nil, serverLockError(err)
+	}
+	return func() {
+		_ = l.Release(ctx)
+		client.Close()
+	}, nil
+}
+
+// serverLockError wraps an error from pg.Client.AcquireAdvisoryLock for the user.
+func serverLockError(err error) error {
+	var locked *pg.AdvisoryLockedError
+	if errors.As(err, &locked) {
+		return fmt.Errorf("%w; see 'hbactl lock status', try again later or raise --lock-timeout", err)
+	}
+	return fmt.Errorf("could not take the server lock: %w", err)
+}
+
+// Execute runs the root command. Commands journal their changes while holding their locks (see
+// flushJournal); any change still unrecorded when the command returns is recorded then.
 func Execute() error {
-	return rootCmd.Execute()
+	return flushJournal(rootCmd.Execute())
 }
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/hrodrig/hbactl/internal/hba"
+)
+
+// saveConfig backs up and rewrites every file of cfg that was modified, and journals the change.
+// Nothing is written if any of them changed on disk since it was read (see retryOnConflict), and if
+// writing one fails, those already written are put back. In filter mode the file is written to
+// stdout instead, changed or not.
+func saveConfig(cfg *hba.Config) error {
+	return flushJournal(writeConfig(cfg))
+}
+
+// writeConfig is saveConfig without the journal.
+func writeConfig(cfg *hba.Config) error {
+	if filterMode() {
+		if _, err := cfg.Root.WriteTo(os.Stdout); err != nil {
+			return fmt.Errorf("failed to write to stdout: %w", err)
+		}
+		return nil
+	}
+	if err := cfg.CheckUnchanged(); err != nil {
+		return err
+	}
+	type written struct {
+		path   string
+		before []byte
+	}
+	var done []written
+	recorded := len(journalChanges)
+	for _, doc := range cfg.Changed() {
+		before, _ := os.ReadFile(doc.Path)
+		if err := backupAndWrite(doc.Path, doc.WriteFile); err != nil {
+			if len(done) == 0 {
+				return err
+			}
+			var errs []error
+			for _, w := range done {
+				if rerr := hba.WriteFileAtomic(w.path, w.before, 0600); rerr != nil {
+					errs = append(errs, rerr)
+				}
+			}
+			if rerr := errors.Join(errs...); rerr != nil {
+				return fmt.Errorf("%w; restoring the %d file(s) already written also failed (see the backups): %v", err, len(done), rerr)
+			}
+			journalChanges = journalChanges[:recorded]
+			return fmt.Errorf("%w; the %d file(s) already written were restored", err, len(done))
+		}
+		done = append(done, written{doc.Path, before})
+	}
+	return nil
+}
+
+// retryOnConflict runs edit, which reads, modifies and saves the configuration. When a file changed
+// on disk while it was being edited (hba.ConflictError), edit is run again on the new content, up
+// to --conflict-retries times.
+func retryOnConflict(edit func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := edit()
+		var conflict *hba.ConflictError
+		if !errors.As(err, &conflict) {
+			return err
+		}
+		if attempt >= conflictRetries {
+			return fmt.Errorf("%w; nothing was written. Run again, or use --conflict-retries to re-read and retry automatically", err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v; re-reading and trying again\n", err)
+	}
+}
+
+// retryUnlessIndexed runs edit with retryOnConflict, unless indexed: an edit that refers to rules
+// by index or line names positions, not rules, and after someone else's edit those may hold other
+// rules, so a conflict is reported instead of re-planned.
+func retryUnlessIndexed(indexed bool, edit func() error) error {
+	if indexed {
+		return edit()
+	}
+	return retryOnConflict(edit)
+}
+
+// backupAndWrite backs up path (see --backup-dir, --backup-gzip), then calls write to replace it,
+// records the change for the journal and prunes old backups (--backup-keep, --backup-max-age). The
+// caller flushes the journal (see flushJournal) before releasing its locks.
+func backupAndWrite(path string, write func(path string) error) error {
+	before, _ := os.ReadFile(path) // an unreadable file fails the backup below
+	backupPath, err := hba.BackupWith(path, backupOpts)
+	if err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("insufficient permissions to write to %s. Try running with sudo", path)
+		}
+		return fmt.Errorf("backup failed: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Backup created at: %s\n", backupPath)
+	if err := write(path); err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("insufficient permissions to write to %s. Try running with sudo", path)
+		}
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	recordChange(path, before, backupPath)
+	// The edit is done: failing to remove old backups is only worth a warning.
+	if _, err := hba.PruneBackups(path, backupOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not remove old backups: %v\n", err)
+	}
+	return nil
+}
+
+// ruleLocation describes where x lives: "line N" in the top-level file, "file:N" in an included one;
+// a backslash-continued rule shows its span ("line N-M").
+func ruleLocation(cfg *hba.Config, x hba.RuleWithLine) string {
+	lines := fmt.Sprintf("%d", x.LineNo)
+	if x.EndLineNo > x.LineNo {
+		lines = fmt.Sprintf("%d-%d", x.LineNo, x.EndLineNo)
+	}
+	if cfg.Document(x.File) == cfg.Root {
+		return "line " + lines
+	}
+	return x.File + ":" + lines
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hrodrig/hbactl/internal/diff"
+	"github.com/hrodrig/hbactl/internal/hba"
+	"github.com/hrodrig/hbactl/internal/journal"
+	"github.com/spf13/cobra"
+)
+
+var undoDryRun bool
+
+var undoCmd = &cobra.Command{
+	Use:   "undo [N]",
+	Short: "Revert the last N changes recorded in the journal (default 1)",
+	Long:  "Restores each file changed by the last N journal entries to its content before the oldest of them, from the backups those entries recorded. Entries that are undos, or were already undone, are skipped, so running undo again goes one more change back. Refused if a file changed since (by hand, or by a run that was not journaled). Creates a backup before writing; the undo is itself journaled. Run 'hbactl reload' to apply changes.",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runUndo,
+}
+
+func init() {
+	rootCmd.AddCommand(undoCmd)
+	undoCmd.Flags().BoolVar(&undoDryRun, "dry-run", false, "Print the changes undoing would make without writing")
+}
+
+func runUndo(cmd *cobra.Command, args []string) error {
+	n := 1
+	if len(args) == 1 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+			return fmt.Errorf("invalid number of changes %q; must be >= 1", args[0])
+		}
+	}
+	ctx := context.Background()
+	path, err := hbaPath(ctx)
+	if err != nil {
+		return err
+	}
+	if !undoDryRun {
+		unlock, err := lockServer(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		lock, err := lockFile(path)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+
+	_, entries, err := readJournal()
+	if err != nil {
+		return err
+	}
+	selected, err := journal.Undoable(entries, n)
+	if err != nil {
+		return err
+	}
+	current := map[string]string{}
+	for _, e := range selected {
+		for _, c := range e.Files {
+			if _, ok := current[c.Path]; ok {
+				continue
+			}
+			data, err := os.ReadFile(c.Path)
+			if err != nil {
+				return fmt.Errorf("could not read file (try running with sudo?): %w", err)
+			}
+			current[c.Path] = journal.Hash(data)
+		}
+	}
+	plan, err := journal.Plan(selected, current)
+	var diverged *journal.DivergedError
+	if errors.As(err, &diverged) {
+		return fmt.Errorf("%w; use 'hbactl backups' to restore an earlier version instead", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Read every backup before writing anything.
+	paths := make([]string, 0, len(plan))
+	for p := range plan {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	contents := map[string][]byte{}
+	for _, p := range paths {
+		c := plan[p]
+		b, err := hba.OpenBackup(c.Backup)
+		if err != nil {
+			return fmt.Errorf("the backup of %s recorded in the journal is gone (pruned?): %w", p, err)
+		}
+		data, err := b.Read()
+		if err != nil {
+			return fmt.Errorf("could not read backup: %w", err)
+		}
+		if journal.Hash(data) != c.Before {
+			return fmt.Errorf("backup %s does not hold what the journal recorded for %s; refusing to undo", c.Backup, p)
+		}
+		contents[p] = data
+	}
+
+	ids := make([]string, len(selected))
+	for i, e := range selected {
+		ids[i] = e.ID
+	}
+	if undoDryRun {
+		fmt.Fprintf(os.Stdout, "dry-run: would undo %d change(s) (%s):\n", len(selected), strings.Join(ids, ", "))
+		for _, p := range paths {
+			cur, err := os.ReadFile(p)
+			if err != nil {
+				return fmt.Errorf("could not read file (try running with sudo?): %w", err)
+			}
+			fmt.Fprint(os.Stdout, diff.Unified(p, p+" (undone)", string(cur), string(contents[p])))
+		}
+		return nil
+	}
+
+	// Set before writing, so that an undo failing partway is journaled as one too.
+	journalUndoes = ids
+	for _, p := range paths {
+		data := contents[p]
+		if err := backupAndWrite(p, func(p string) error { return hba.WriteFileAtomic(p, data, 0600) }); err != nil {
+			return flushJournal(err)
+		}
+	}
+	if err := flushJournal(nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Success: undid %d change(s) (%s). Run 'hbactl reload' to apply changes.\n", len(selected), strings.Join(ids, ", "))
+	return nil
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/hrodrig/hbactl/internal/hba"
+	"github.com/spf13/cobra"
+)
+
+var (
+	updateIndex   int
+	updateMatch   string
+	updateMethod  string
+	updateAddr    string
+	updateDB      string
+	updateUser    string
+	updateSetOpts []string
+	updateUnset   []string
+	updateDryRun  bool
+)
+
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Change a rule in place (method, address, database, user, options)",
+	Long:  "Changes one rule by --index, or every rule matching --match, in place: the rule keeps its position, its inline comment and its column alignment (e.g. --set-method scram-sha-256 to move off md5, or --set-addr to widen a CIDR). The updated rules are validated (for the server's PostgreSQL version, or --pg-version) and nothing is written if PostgreSQL would reject one. Creates a backup before writing. Use --dry-run to preview. Run 'hbactl reload' after to apply changes.",
+	Args:  cobra.NoArgs,
+	RunE:  runUpdate,
+}
+
+func init() {
+	rootCmd.AddCommand(updateCmd)
+	updateCmd.Flags().IntVar(&updateIndex, "index", 0, "1-based rule index to update (see first column of 'hbactl list')")
+	updateCmd.Flags().StringVar(&updateMatch, "match", "", "Update every rule matching this filter (e.g. 'method=md5', 'user=alice db=app'; fields: type, db, user, addr, method)")
+	updateCmd.Flags().StringVar(&updateMethod, "set-method", "", "New auth method (e.g. scram-sha-256)")
+	updateCmd.Flags().StringVar(&updateAddr, "set-addr", "", "New address (e.g. 10.0.0.0/16); a CIDR, keyword or host name drops a legacy netmask")
+	updateCmd.Flags().StringVar(&updateDB, "set-db", "", "New database column (e.g. app or app,reports)")
+	updateCmd.Flags().StringVar(&updateUser, "set-user", "", "New user column (e.g. alice or +admins)")
+	updateCmd.Flags().StringArrayVar(&updateSetOpts, "set-option", nil, "Set auth option name=value, replacing one of the same name or appending it; repeat for several")
+	updateCmd.Flags().StringArrayVar(&updateUnset, "unset-option", nil, "Remove auth option by name (e.g. map); repeat for several")
+	updateCmd.Flags().BoolVar(&updateDryRun, "dry-run", false, "Print the rule(s) before and after without writing or creating backup")
+}
+
+func runUpdate(cmd *cobra.Command, _ []string) error {
+	byIndex := cmd.Flags().Changed("index")
+	byMatch := strings.TrimSpace(updateMatch) != ""
+	if byIndex == byMatch {
+		return fmt.Errorf("specify either --index N or --match FILTER")
+	}
+	if byIndex && updateIndex < 1 {
+		return fmt.Errorf("--index must be >= 1")
+	}
+	var filter hba.Filter
+	if byMatch {
+		f, err := hba.ParseFilter(updateMatch)
+		if err != nil {
+			return err
+		}
+		filter = f
+	}
+	u := hba.Update{DB: updateDB, User: updateUser, Addr: updateAddr, Method: updateMethod}
+	for _, s := range updateSetOpts {
+		o, err := hba.ParseOption(s)
+		if err != nil {
+			return err
+		}
+		u.SetOptions = append(u.SetOptions, o)
+	}
+	for _, name := range updateUnset {
+		if name = strings.TrimSpace(name); name != "" {
+			u.UnsetOptions = append(u.UnsetOptions, name)
+		}
+	}
+	if reflect.DeepEqual(u, hba.Update{}) {
+		return fmt.Errorf("nothing to change: use --set-method, --set-addr, --set-db, --set-user, --set-option or --unset-option")
+	}
+
+	path, err := hbaPath(context.Background())
+	if err != nil {
+		return err
+	}
+	if !updateDryRun {
+		unlock, err := lockServer(context.Background())
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		lock, err := lockFile(path)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+	var selected *hba.Filter // nil: the rule at --index
+	if byMatch {
+		selected = &filter
+	}
+	return retryUnlessIndexed(byIndex, func() error { return updateRules(path, u, selected) })
+}
+
+// updateRules reads the configuration at path, applies u to the rule at --index or to every rule
+// matching filter, and saves it.
+func updateRules(path string, u hba.Update, filter *hba.Filter) error {
+	cfg, err := loadConfig(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	rules := cfg.Rules()
+	var selected []hba.RuleWithLine
+	if filter == nil {
+		x, ok := cfg.Rule(updateIndex)
+		if !ok {
+			return fmt.Errorf("no rule at index %d (file has %d rule(s)); run 'hbactl list' to see indices", updateIndex, len(rules))
+		}
+		selected = append(selected, x)
+	} else {
+		for _, x := range rules {
+			if filter.Match(x) {
+				selected = append(selected, x)
+			}
+		}
+		if len(selected) == 0 {
+			return fmt.Errorf("no rules matching %q", filter.String())
+		}
+	}
+
+	type change struct {
+		x      hba.RuleWithLine
+		before string
+	}
+	var changes []change
+	var invalid int
+	for _, x := range selected {
+		r, err := u.Apply(x.Rule)
+		if err != nil {
+			return fmt.Errorf("rule #%d (%s): %w", x.Index, ruleLocation(cfg, x), err)
+		}
+		if reflect.DeepEqual(r, x.Rule) {
+			fmt.Fprintf(os.Stderr, "Rule #%d (%s) is already as requested; left unchanged\n", x.Index, ruleLocation(cfg, x))
+			continue
+		}
+		if errs := r.Validate(cfg.Version); len(errs) > 0 {
+			for _, e := range errs {
+				fmt.Fprintf(os.Stderr, "Error: rule #%d (%s): %v\n", x.Index, ruleLocation(cfg, x), e)
+			}
+			invalid++
+			continue
+		}
+		before := cfg.Node(x).Text()
+		if err := cfg.ReplaceRule(x, r); err != nil {
+			return fmt.Errorf("failed to update rule #%d: %w", x.Index, err)
+		}
+		changes = append(changes, change{x, before})
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d updated rule(s) would be rejected by PostgreSQL (target version %s); nothing written", invalid, cfg.Version)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(msgOut(), "No changes.")
+		if filterMode() && !updateDryRun {
+			return saveConfig(cfg) // pass the file through unchanged
+		}
+		return nil
+	}
+
+	out := msgOut()
+	if updateDryRun {
+		fmt.Fprintf(out, "dry-run: would update %d rule(s) in %s:\n", len(changes), path)
+	} else {
+		fmt.Fprintf(out, "Updating %d rule(s):\n", len(changes))
+	}
+	for _, c := range changes {
+		fmt.Fprintf(out, "  #%d (%s): %s\n  → %s\n", c.x.Index, ruleLocation(cfg, c.x), c.before, cfg.Node(c.x).Text())
+	}
+	if updateDryRun {
+		return nil
+	}
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Success: %d rule(s) updated in %s. Run 'hbactl reload' to apply changes.\n", len(changes), path)
+	return nil
+}
@@ -0,0 +1,307 @@
+// Package batch reads change sets, ordered lists of add, remove and move operations on pg_hba.conf
+// rules, and applies them to a configuration as one edit.
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hrodrig/hbactl/internal/hba"
+	"gopkg.in/yaml.v3"
+)
+
+// Batch is a change set. In YAML (or JSON):
+//
+//	reason: OPS-1234 onboard alice
+//	operations:
+//	  - add: {type: host, db: app, user: alice, addr: 10.0.1.7/32, method: scram-sha-256}
+//	  - remove: {index: 3}
+//	  - move: {index: 5, before: 2}
+type Batch struct {
+	Reason     string      `yaml:"reason"`
+	Operations []Operation `yaml:"operations"`
+}
+
+// Operation is one step of a batch: exactly one of Add, Remove and Move is set.
+type Operation struct {
+	Add    *Add    `yaml:"add"`
+	Remove *Remove `yaml:"remove"`
+	Move   *Move   `yaml:"move"`
+}
+
+// Add adds a rule, like 'hbactl add'. It is appended to the top-level file unless AfterUser is set.
+type Add struct {
+	Type      string   `yaml:"type"`
+	DB        string   `yaml:"db"`
+	User      string   `yaml:"user"`
+	Addr      string   `yaml:"addr"`
+	Netmask   string   `yaml:"netmask"`
+	Method    string   `yaml:"method"`
+	Options   []string `yaml:"options"` // name=value
+	AfterUser string   `yaml:"after_user"`
+}
+
+// Remove removes the rule at Index, or every rule listing User (and DB, if set), or every rule
+// matching Addr, like 'hbactl remove'.
+type Remove struct {
+	Index int    `yaml:"index"`
+	User  string `yaml:"user"`
+	DB    string `yaml:"db"`
+	Addr  string `yaml:"addr"`
+}
+
+// Move moves the rule at Index, with the comment lines directly above it, just before rule Before,
+// just after rule After, or to where rule To is (before it when moving up, after it when moving
+// down), like 'hbactl move'.
+type Move struct {
+	Index  int `yaml:"index"`
+	To     int `yaml:"to"`
+	Before int `yaml:"before"`
+	After  int `yaml:"after"`
+}
+
+// Parse reads a batch from r and checks that every operation is well-formed. Unknown fields are
+// errors, so that a typo does not silently change what an operation does.
+func Parse(r io.Reader) (*Batch, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	var b Batch
+	if err := dec.Decode(&b); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no operations")
+		}
+		return nil, err
+	}
+	if len(b.Operations) == 0 {
+		return nil, fmt.Errorf("no operations")
+	}
+	for i, op := range b.Operations {
+		if err := op.check(); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i+1, err)
+		}
+	}
+	return &b, nil
+}
+
+// UsesIndex reports whether any operation refers to a rule by its index. An index names a position,
+// not a rule, so such a batch must not be re-planned on a file that changed in between.
+func (b *Batch) UsesIndex() bool {
+	for _, op := range b.Operations {
+		if (op.Remove != nil && op.Remove.Index != 0) || op.Move != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// kind names the operation in messages.
+func (op Operation) kind() string {
+	switch {
+	case op.Add != nil:
+		return "add"
+	case op.Remove != nil:
+		return "remove"
+	}
+	return "move"
+}
+
+// check reports a malformed operation.
+func (op Operation) check() error {
+	n := 0
+	for _, set := range []bool{op.Add != nil, op.Remove != nil, op.Move != nil} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("specify exactly one of add, remove or move")
+	}
+	switch {
+	case op.Add != nil:
+		if op.Add.Type == "" || op.Add.Method == "" {
+			return fmt.Errorf("add: type and method are required")
+		}
+	case op.Remove != nil:
+		r := op.Remove
+		by := 0
+		for _, set := range []bool{r.Index != 0, r.User != "", r.Addr != ""} {
+			if set {
+				by++
+			}
+		}
+		if by != 1 {
+			return fmt.Errorf("remove: specify exactly one of index, user or addr")
+		}
+		if r.Index < 0 {
+			return fmt.Errorf("remove: index must be >= 1")
+		}
+		if r.DB != "" && r.User == "" {
+			return fmt.Errorf("remove: db requires user")
+		}
+	case op.Move != nil:
+		m := op.Move
+		if m.Index < 1 {
+			return fmt.Errorf("move: index must be >= 1")
+		}
+		by := 0
+		for _, v := range []int{m.To, m.Before, m.After} {
+			if v < 0 {
+				return fmt.Errorf("move: to, before and after must be >= 1")
+			}
+			if v != 0 {
+				by++
+			}
+		}
+		if by != 1 {
+			return fmt.Errorf("move: specify exactly one of to, before or after")
+		}
+	}
+	return nil
+}
+
+// Apply applies the operations of b to cfg in order and returns a description of each. Indices and
+// the user/addr criteria of remove refer to the rules as they were before the batch (as 'hbactl
+// list' numbers them), whatever earlier operations did. Added rules are validated for the target
+// version v. On error cfg is left partially modified and must be discarded.
+func (b *Batch) Apply(cfg *hba.Config, v hba.Version) ([]string, error) {
+	a := applier{cfg: cfg, version: v, orig: cfg.Rules()}
+	for _, x := range a.orig {
+		a.nodes = append(a.nodes, cfg.Node(x))
+	}
+	var steps []string
+	for i, op := range b.Operations {
+		var step string
+		var err error
+		switch {
+		case op.Add != nil:
+			step, err = a.add(op.Add)
+		case op.Remove != nil:
+			step, err = a.remove(op.Remove)
+		default:
+			step, err = a.move(op.Move)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i+1, op.kind(), err)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// applier applies operations to cfg, resolving rule references against orig.
+type applier struct {
+	cfg     *hba.Config
+	version hba.Version
+	orig    []hba.RuleWithLine // the rules before the batch
+	nodes   []*hba.Node        // the node holding each rule of orig, which edits do not change
+}
+
+// rule returns the rule that had the given index before the batch, where it is now.
+func (a *applier) rule(index int) (hba.RuleWithLine, error) {
+	if index < 1 || index > len(a.orig) {
+		return hba.RuleWithLine{}, fmt.Errorf("no rule at index %d (file has %d rule(s))", index, len(a.orig))
+	}
+	x, ok := a.cfg.Find(a.nodes[index-1])
+	if !ok {
+		return hba.RuleWithLine{}, fmt.Errorf("rule #%d was removed by an earlier operation", index)
+	}
+	return x, nil
+}
+
+func (a *applier) add(op *Add) (string, error) {
+	var options []hba.Option
+	for _, s := range op.Options {
+		o, err := hba.ParseOption(s)
+		if err != nil {
+			return "", err
+		}
+		options = append(options, o)
+	}
+	r, err := hba.NewRule(op.Type, op.DB, op.User, op.Addr, op.Netmask, op.Method, options)
+	if err != nil {
+		return "", err
+	}
+	if errs := r.Validate(a.version); len(errs) > 0 {
+		return "", errors.Join(errs...)
+	}
+	var doc *hba.Document
+	where := "append to"
+	if op.AfterUser != "" {
+		doc, err = a.cfg.InsertRuleAfterUser(r, strings.TrimSpace(op.AfterUser))
+		where = fmt.Sprintf("insert after the last rule for user %q in", op.AfterUser)
+	} else {
+		doc, err = a.cfg.AppendRule(r)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("add (%s %s): %s", where, doc.Path, r.Line()), nil
+}
+
+func (a *applier) remove(op *Remove) (string, error) {
+	var matched []hba.RuleWithLine
+	if op.Index != 0 {
+		x, err := a.rule(op.Index)
+		if err != nil {
+			return "", err
+		}
+		matched = append(matched, x)
+	} else {
+		user, db, addr := strings.TrimSpace(op.User), strings.TrimSpace(op.DB), strings.TrimSpace(op.Addr)
+		for i, o := range a.orig {
+			if (user != "" && o.MatchesUser(user, db)) || (addr != "" && o.Rule.MatchesAddress(addr)) {
+				if x, ok := a.cfg.Find(a.nodes[i]); ok {
+					matched = append(matched, x)
+				}
+			}
+		}
+		if len(matched) == 0 {
+			return "", fmt.Errorf("no rules matching user %q db %q addr %q", user, db, addr)
+		}
+	}
+	lines := make([]string, len(matched))
+	for i, x := range matched {
+		lines[i] = fmt.Sprintf("#%d: %s", a.origIndex(x), x.Rule.Line())
+	}
+	a.cfg.Remove(matched)
+	return "remove " + strings.Join(lines, "; "), nil
+}
+
+func (a *applier) move(op *Move) (string, error) {
+	x, err := a.rule(op.Index)
+	if err != nil {
+		return "", err
+	}
+	targetIndex, after := op.Before, false
+	switch {
+	case op.After != 0:
+		targetIndex, after = op.After, true
+	case op.To != 0:
+		targetIndex, after = op.To, op.To > op.Index
+	}
+	target, err := a.rule(targetIndex)
+	if err != nil {
+		return "", err
+	}
+	if err := a.cfg.MoveRule(x, target, after); err != nil {
+		return "", err
+	}
+	rel := "before"
+	if after {
+		rel = "after"
+	}
+	return fmt.Sprintf("move #%d %s #%d: %s", op.Index, rel, targetIndex, x.Rule.Line()), nil
+}
+
+// origIndex returns the index x had before the batch.
+func (a *applier) origIndex(x hba.RuleWithLine) int {
+	n := a.cfg.Node(x)
+	for i, o := range a.orig {
+		if a.nodes[i] == n {
+			return o.Index
+		}
+	}
+	return x.Index
+}
@@ -0,0 +1,114 @@
+package batch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hrodrig/hbactl/internal/hba"
+)
+
+const conf = `# TYPE  DATABASE  USER   ADDRESS       METHOD
+local   all       all                  peer
+host    all       alice  10.0.0.1/32   md5
+host    app       bob    10.0.0.2/32   md5
+host    all       all    0.0.0.0/0     reject
+`
+
+func TestParse(t *testing.T) {
+	b, err := Parse(strings.NewReader(`
+reason: TICKET-1
+operations:
+  - add: {type: host, db: app, user: carol, addr: 10.0.0.3/32, method: scram-sha-256}
+  - remove: {user: bob}
+  - move: {index: 2, before: 1}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Reason != "TICKET-1" || len(b.Operations) != 3 || b.Operations[0].Add.User != "carol" || b.Operations[2].Move.Before != 1 {
+		t.Errorf("Parse: got %+v", b)
+	}
+
+	// JSON is YAML too.
+	if _, err := Parse(strings.NewReader(`{"operations": [{"remove": {"index": 3}}]}`)); err != nil {
+		t.Errorf("Parse JSON: %v", err)
+	}
+
+	for _, tc := range []struct{ in, want string }{
+		{``, "no operations"},
+		{`operations: []`, "no operations"},
+		{`operations: [{remove: {index: 1}, move: {index: 1, to: 2}}]`, "operation 1: specify exactly one"},
+		{`operations: [{remove: {idx: 1}}]`, "field idx not found"},
+		{`operations: [{remove: {index: 1, user: bob}}]`, "exactly one of index, user or addr"},
+		{`operations: [{remove: {db: app}}]`, "exactly one of index, user or addr"},
+		{`operations: [{move: {index: 1}}]`, "exactly one of to, before or after"},
+		{`operations: [{add: {type: host, user: bob}}]`, "type and method are required"},
+	} {
+		if _, err := Parse(strings.NewReader(tc.in)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Parse(%q): got %v, want an error containing %q", tc.in, err, tc.want)
+		}
+	}
+}
+
+func apply(t *testing.T, in string) (string, []string, error) {
+	t.Helper()
+	b, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := hba.ReadConfig(strings.NewReader(conf), "pg_hba.conf", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps, err := b.Apply(cfg, 16)
+	return string(cfg.Root.Bytes()), steps, err
+}
+
+func rules(content string) []string {
+	var result []string
+	for _, line := range strings.Split(content, "\n") {
+		if f := strings.Fields(line); len(f) > 0 && !strings.HasPrefix(f[0], "#") {
+			result = append(result, strings.Join(f, " "))
+		}
+	}
+	return result
+}
+
+func TestApply(t *testing.T) {
+	// Indices refer to the rules before the batch: #4 is still the reject rule after #2 is removed.
+	got, steps, err := apply(t, `
+operations:
+  - remove: {index: 2}
+  - add: {type: host, db: app, user: carol, addr: 10.0.0.3/32, method: scram-sha-256, after_user: bob}
+  - move: {index: 4, to: 1}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"host all all 0.0.0.0/0 reject",
+		"local all all peer",
+		"host app bob 10.0.0.2/32 md5",
+		"host app carol 10.0.0.3/32 scram-sha-256",
+	}
+	if r := rules(got); strings.Join(r, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Apply: got rules\n%s\nwant\n%s", strings.Join(r, "\n"), strings.Join(want, "\n"))
+	}
+	if len(steps) != 3 || !strings.HasPrefix(steps[0], "remove #2: ") || !strings.HasPrefix(steps[2], "move #4 before #1: ") {
+		t.Errorf("Apply steps: got %q", steps)
+	}
+}
+
+func TestApply_errors(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{`operations: [{remove: {index: 2}}, {move: {index: 2, to: 1}}]`, "operation 2 (move): rule #2 was removed by an earlier operation"},
+		{`operations: [{remove: {index: 9}}]`, "operation 1 (remove): no rule at index 9"},
+		{`operations: [{remove: {user: nobody}}]`, "operation 1 (remove): no rules matching"},
+		{`operations: [{add: {type: host, addr: 10.0.0.0/8, method: trust}}, {add: {type: hots, method: md5}}]`, "operation 2 (add)"},
+		{`operations: [{add: {type: host, addr: 10.0.0.0/8, method: trust, options: ["bogus"]}}]`, "operation 1 (add)"},
+	} {
+		if _, _, err := apply(t, tc.in); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Apply(%q): got %v, want an error containing %q", tc.in, err, tc.want)
+		}
+	}
+}
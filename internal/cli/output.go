@@ -3,6 +3,8 @@ package cli
 import (
 	"fmt"
 	"io"
+	"path/filepath"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/hrodrig/hbactl/internal/hba"
@@ -11,32 +13,22 @@ import (
 // WriteRulesTable prints rules in a formatted table to w.
 func WriteRulesTable(w io.Writer, rules []hba.Rule) {
 	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "TYPE\tDATABASE\tUSER\tADDRESS\tMETHOD")
-	fmt.Fprintln(tw, "----\t--------\t----\t-------\t------")
+	fmt.Fprintln(tw, "TYPE\tDATABASE\tUSER\tADDRESS\tMETHOD\tOPTIONS")
+	fmt.Fprintln(tw, "----\t--------\t----\t-------\t------\t-------")
 	for _, r := range rules {
 		addr := r.Address
 		if r.Netmask != "" {
 			addr = r.Address + " / " + r.Netmask
 		}
-		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Type, r.Database, r.User, addr, r.Method)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Type, formatTokens(r.Databases()), formatTokens(r.Users()), addr, r.Method, hba.FormatOptions(r.Options))
 	}
 	tw.Flush()
 }
 
 // WriteRulesTableWithIndex prints rules with a 1-based index column (#). Use for list when remove is available.
+// When rules come from more than one file (include directives), a SOURCE column shows file:line.
 func WriteRulesTableWithIndex(w io.Writer, rwl []hba.RuleWithLine) {
-	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "#\tTYPE\tDATABASE\tUSER\tADDRESS\tMETHOD")
-	fmt.Fprintln(tw, "-\t----\t--------\t----\t-------\t------")
-	for _, x := range rwl {
-		r := x.Rule
-		addr := r.Address
-		if r.Netmask != "" {
-			addr = r.Address + " / " + r.Netmask
-		}
-		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", x.Index, r.Type, r.Database, r.User, addr, r.Method)
-	}
-	tw.Flush()
+	writeRulesTableWithIndexTo(w, rwl, multipleFiles(rwl))
 }
 
 // WriteRulesTableGroupedByUser prints rules in a table with "=== user: X ===" separators and index column.
@@ -45,50 +37,100 @@ func WriteRulesTableGroupedByUser(w io.Writer, rwl []hba.RuleWithLine) {
 	if len(rwl) == 0 {
 		return
 	}
+	withSource := multipleFiles(rwl)
 	var group []hba.RuleWithLine
 	curUser := ""
 	for _, x := range rwl {
-		if x.Rule.User != curUser {
+		user := columnText(x.Rule.Users(), x.UserTokens())
+		if user != curUser {
 			if len(group) > 0 {
 				fmt.Fprintf(w, "\n=== user: %s ===\n\n", curUser)
-				writeRulesTableWithIndexTo(w, group)
+				writeRulesTableWithIndexTo(w, group, withSource)
 			}
-			curUser = x.Rule.User
+			curUser = user
 			group = group[:0]
 		}
 		group = append(group, x)
 	}
 	if len(group) > 0 {
 		fmt.Fprintf(w, "\n=== user: %s ===\n\n", curUser)
-		writeRulesTableWithIndexTo(w, group)
+		writeRulesTableWithIndexTo(w, group, withSource)
 	}
 }
 
-func writeRulesTableWithIndexTo(w io.Writer, rwl []hba.RuleWithLine) {
+func writeRulesTableWithIndexTo(w io.Writer, rwl []hba.RuleWithLine, withSource bool) {
 	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "#\tTYPE\tDATABASE\tUSER\tADDRESS\tMETHOD")
-	fmt.Fprintln(tw, "-\t----\t--------\t----\t-------\t------")
+	if withSource {
+		fmt.Fprintln(tw, "#\tTYPE\tDATABASE\tUSER\tADDRESS\tMETHOD\tOPTIONS\tSOURCE")
+		fmt.Fprintln(tw, "-\t----\t--------\t----\t-------\t------\t-------\t------")
+	} else {
+		fmt.Fprintln(tw, "#\tTYPE\tDATABASE\tUSER\tADDRESS\tMETHOD\tOPTIONS")
+		fmt.Fprintln(tw, "-\t----\t--------\t----\t-------\t------\t-------")
+	}
 	for _, x := range rwl {
 		r := x.Rule
 		addr := r.Address
 		if r.Netmask != "" {
 			addr = r.Address + " / " + r.Netmask
 		}
-		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", x.Index, r.Type, r.Database, r.User, addr, r.Method)
+		db := columnText(r.Databases(), x.DatabaseTokens())
+		user := columnText(r.Users(), x.UserTokens())
+		opts := hba.FormatOptions(r.Options)
+		if withSource {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s:%d\n", x.Index, r.Type, db, user, addr, r.Method, opts, filepath.Base(x.File), x.LineNo)
+		} else {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", x.Index, r.Type, db, user, addr, r.Method, opts)
+		}
 	}
 	tw.Flush()
 }
 
+// formatTokens renders a database or user list for display. Regex entries are shown as
+// "regex:<pattern>" so they cannot be mistaken for names.
+func formatTokens(tokens []hba.Token) string {
+	parts := make([]string, len(tokens))
+	for i, t := range tokens {
+		if t.Kind == hba.TokenRegex {
+			parts[i] = "regex:" + t.Value
+		} else {
+			parts[i] = t.String()
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// columnText renders a database or user column as written; when it has @file entries, the names
+// they expand to follow in brackets (e.g. "@admins.txt [alice,bob]").
+func columnText(written, expanded []hba.Token) string {
+	s := formatTokens(written)
+	for _, t := range written {
+		if t.Kind == hba.TokenFile {
+			return s + " [" + formatTokens(expanded) + "]"
+		}
+	}
+	return s
+}
+
+// multipleFiles reports whether rwl holds rules from more than one file.
+func multipleFiles(rwl []hba.RuleWithLine) bool {
+	for _, x := range rwl {
+		if x.File != rwl[0].File {
+			return true
+		}
+	}
+	return false
+}
+
 func writeRulesTableTo(w io.Writer, rules []hba.Rule) {
 	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "TYPE\tDATABASE\tUSER\tADDRESS\tMETHOD")
-	fmt.Fprintln(tw, "----\t--------\t----\t-------\t------")
+	fmt.Fprintln(tw, "TYPE\tDATABASE\tUSER\tADDRESS\tMETHOD\tOPTIONS")
+	fmt.Fprintln(tw, "----\t--------\t----\t-------\t------\t-------")
 	for _, r := range rules {
 		addr := r.Address
 		if r.Netmask != "" {
 			addr = r.Address + " / " + r.Netmask
 		}
-		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Type, r.Database, r.User, addr, r.Method)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Type, formatTokens(r.Databases()), formatTokens(r.Users()), addr, r.Method, hba.FormatOptions(r.Options))
 	}
 	tw.Flush()
 }
@@ -35,3 +35,14 @@ func TestWriteRulesTable_empty(t *testing.T) {
 		t.Errorf("empty table should still have header: %s", out)
 	}
 }
+
+func TestWriteRulesTableWithIndex_regex(t *testing.T) {
+	rwl := []hba.RuleWithLine{
+		{Rule: hba.Rule{Type: "host", Database: "all", User: "alice,/^tenant_", Address: "10.0.0.0/8", Method: "md5"}, Index: 1},
+	}
+	var buf bytes.Buffer
+	WriteRulesTableWithIndex(&buf, rwl)
+	if out := buf.String(); !strings.Contains(out, "alice,regex:^tenant_") {
+		t.Errorf("regex entry not rendered distinctly: %s", out)
+	}
+}
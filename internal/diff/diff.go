@@ -0,0 +1,189 @@
+// Package diff compares texts line by line and formats the result as a unified diff.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Context is the number of unchanged lines shown around each change.
+const Context = 3
+
+// Op is the kind of an Edit.
+type Op byte
+
+const (
+	Equal  Op = ' '
+	Delete Op = '-'
+	Insert Op = '+'
+)
+
+// Edit is one line of an edit script: a line kept, deleted from the old text or inserted from the
+// new one. Text includes its newline, if it has one.
+type Edit struct {
+	Op   Op
+	Text string
+}
+
+// Lines splits s into lines, each keeping its newline (the last one may have none).
+func Lines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Edits returns a shortest edit script turning the lines a into b (Myers' algorithm).
+func Edits(a, b []string) []Edit {
+	// Common prefix and suffix need no search.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var edits []Edit
+	for _, l := range a[:pre] {
+		edits = append(edits, Edit{Equal, l})
+	}
+	edits = append(edits, myers(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, l := range a[len(a)-suf:] {
+		edits = append(edits, Edit{Equal, l})
+	}
+	return edits
+}
+
+// frontier is the furthest x reached on each diagonal k = x - y after some number of edits, for
+// k in [lo, lo+len(x)).
+type frontier struct {
+	lo int
+	x  []int
+}
+
+func (f frontier) at(k int) int { return f.x[k-f.lo] }
+
+// myers computes the edit script of a and b by searching for the furthest-reaching paths with
+// d = 0, 1, ... edits, then walking back through the recorded frontiers.
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace []frontier
+	x, y := 0, 0
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, frontier{lo: -d - 1, x: append([]int(nil), v[offset-d-1:offset+d+2]...)})
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // step down: insert b[y]
+			} else {
+				x = v[offset+k-1] + 1 // step right: delete a[x]
+			}
+			y = x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var rev []Edit
+	for d := len(trace) - 1; d >= 0; d-- {
+		f := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && f.at(k-1) < f.at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := f.at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			rev = append(rev, Edit{Equal, a[x]})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			rev = append(rev, Edit{Insert, b[prevY]})
+		} else {
+			rev = append(rev, Edit{Delete, a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	edits := make([]Edit, len(rev))
+	for i, e := range rev {
+		edits[len(rev)-1-i] = e
+	}
+	return edits
+}
+
+// Unified returns the unified diff (as diff -u prints it) turning a, named aName, into b, named
+// bName. It returns "" if the texts are equal.
+func Unified(aName, bName, a, b string) string {
+	edits := Edits(Lines(a), Lines(b))
+	var out strings.Builder
+	aLine, bLine := 0, 0 // lines of a and b before edits[i]
+	for i := 0; i < len(edits); {
+		for i < len(edits) && edits[i].Op == Equal {
+			i, aLine, bLine = i+1, aLine+1, bLine+1
+		}
+		if i == len(edits) {
+			break
+		}
+		// The hunk runs from Context lines before the first change to Context lines after the last
+		// change separated from the previous one by at most 2*Context unchanged lines.
+		start := max(i-Context, 0)
+		last := i
+		for j := i + 1; j < len(edits) && j-last <= 2*Context+1; j++ {
+			if edits[j].Op != Equal {
+				last = j
+			}
+		}
+		end := min(last+Context+1, len(edits))
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		var aCount, bCount int
+		for _, e := range edits[start:end] {
+			if e.Op != Insert {
+				aCount++
+			}
+			if e.Op != Delete {
+				bCount++
+			}
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, e := range edits[start:end] {
+			out.WriteByte(byte(e.Op))
+			out.WriteString(e.Text)
+			if !strings.HasSuffix(e.Text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		aLine, bLine = aStart+aCount, bStart+bCount
+		i = end
+	}
+	return out.String()
+}
+
+// hunkRange formats the line range of a hunk side that follows start lines: "5,3", "5" for a single
+// line, "4,0" for none.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,152 @@
+package hba
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Warn reports a problem that does not stop an operation, such as an attribute that could not be
+// kept on a rewritten file. It prints to stderr; tests may replace it.
+var Warn = func(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", args...)
+}
+
+// WriteFileAtomic replaces path with data so that readers (and PostgreSQL on reload) see either the
+// old or the new content, never a partial file: data is written to a temporary file in the same
+// directory, synced to disk, renamed over path, and the directory is synced so the rename survives
+// a crash. An existing file keeps its permission bits, owner and extended attributes (such as its
+// SELinux context); a new one is created with perm. When not running as root, an owner or attribute
+// that the user may not set is left as the system makes it, with a warning.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileLike(path, data, path, perm)
+}
+
+// writeFileLike is WriteFileAtomic, taking the attributes from the file ref instead (if it exists).
+// A symlink at path is followed: the file it points to is replaced and the link is kept.
+func writeFileLike(path string, data []byte, ref string, perm os.FileMode) (err error) {
+	if path, err = followSymlink(path); err != nil {
+		return err
+	}
+	attrs, err := readAttrs(ref)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if attrs != nil {
+		err = attrs.apply(tmp, path)
+	} else {
+		err = tmp.Chmod(perm)
+	}
+	if err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// followSymlink returns the file path points to when it is a symlink (e.g. a pg_hba.conf linked
+// from a configuration management checkout), and path itself otherwise.
+func followSymlink(path string) (string, error) {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		return path, nil
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("could not follow the symlink %s: %w", path, err)
+	}
+	return target, nil
+}
+
+// fileAttrs are the attributes of a file that a rewrite must keep.
+type fileAttrs struct {
+	mode   os.FileMode
+	uid    int // -1 when not available (Windows)
+	gid    int
+	xattrs map[string][]byte // extended attributes (Linux), e.g. security.selinux
+}
+
+// readAttrs reads the attributes of path.
+func readAttrs(path string) (*fileAttrs, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &fileAttrs{mode: fi.Mode().Perm()}
+	a.uid, a.gid = fileOwner(fi)
+	if a.xattrs, err = readXattrs(path); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// apply sets the attributes on f, which will become path: ownership first, since changing the owner
+// may clear setuid bits. Without root, failing to change the owner or to set an attribute the user
+// may not set (such as trusted.*) is a warning: the file is written all the same.
+func (a *fileAttrs) apply(f *os.File, path string) error {
+	if a.uid >= 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if uid, gid := fileOwner(fi); uid != a.uid || gid != a.gid {
+			if err := f.Chown(a.uid, a.gid); err != nil {
+				if !notPermitted(err) {
+					return err
+				}
+				Warn("could not keep the owner of %s (uid %d, gid %d): %v; it is now owned by uid %d, gid %d", path, a.uid, a.gid, err, uid, gid)
+			}
+		}
+	}
+	if err := f.Chmod(a.mode); err != nil {
+		return err
+	}
+	for name, value := range a.xattrs {
+		if err := writeXattr(f.Name(), name, value); err != nil {
+			if !notPermitted(err) {
+				return err
+			}
+			Warn("could not keep the extended attribute %s of %s: %v", name, path, err)
+		}
+	}
+	return nil
+}
+
+// notPermitted reports whether err is a permission error that a user other than root should
+// expect when keeping the attributes of a file owned by someone else.
+func notPermitted(err error) bool {
+	return errors.Is(err, fs.ErrPermission) && os.Geteuid() != 0
+}
+
+// InsecureMode reports whether a pg_hba.conf with mode m is readable by others or writable by
+// group or others. The file can hold LDAP and RADIUS secrets; 0600 or 0640 is expected.
+func InsecureMode(m os.FileMode) bool {
+	return m.Perm()&0o026 != 0
+}
@@ -0,0 +1,120 @@
+package hba
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pg_hba.conf")
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(path, []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "new\n" {
+		t.Errorf("content: got %q", data)
+	}
+	fi, _ := os.Stat(path)
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("existing mode should be kept: got %v", fi.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+
+	created := filepath.Join(dir, "new.conf")
+	if err := WriteFileAtomic(created, []byte("x"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(created); fi.Mode().Perm() != 0640 {
+		t.Errorf("new file mode: got %v", fi.Mode().Perm())
+	}
+
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "x"), []byte("x"), 0644); err == nil {
+		t.Error("write into a missing directory should fail")
+	}
+}
+
+func TestBackup_keepsMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pg_hba.conf")
+	if err := os.WriteFile(path, []byte("local all all peer\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := Backup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(backup); fi.Mode().Perm() != 0640 {
+		t.Errorf("backup mode: got %v, want 0640", fi.Mode().Perm())
+	}
+}
+
+func TestInsecureMode(t *testing.T) {
+	for mode, want := range map[os.FileMode]bool{0600: false, 0640: false, 0644: true, 0660: true, 0602: true} {
+		if got := InsecureMode(mode); got != want {
+			t.Errorf("InsecureMode(%04o) = %v, want %v", mode, got, want)
+		}
+	}
+}
+
+func TestFileAttrs_applyNotRoot(t *testing.T) {
+	if os.Geteuid() <= 0 {
+		t.Skip("needs a user other than root")
+	}
+	f, err := os.CreateTemp(t.TempDir(), "pg_hba.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var warnings int
+	defer func(w func(string, ...any)) { Warn = w }(Warn)
+	Warn = func(string, ...any) { warnings++ }
+	// root's file, as a member of its group with write access would see it.
+	a := &fileAttrs{mode: 0640, uid: 0, gid: 0, xattrs: map[string][]byte{"trusted.hbactl": []byte("x")}}
+	if err := a.apply(f, "pg_hba.conf"); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if warnings == 0 {
+		t.Error("want a warning for the owner that could not be kept")
+	}
+	if fi, _ := f.Stat(); fi.Mode().Perm() != 0640 {
+		t.Errorf("mode: got %v, want 0640", fi.Mode().Perm())
+	}
+}
+
+func TestWriteFileAtomic_followsSymlink(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real", "pg_hba.conf")
+	if err := os.Mkdir(filepath.Dir(real), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(real, []byte("old\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.conf")
+	if err := os.Symlink(filepath.Join("real", "pg_hba.conf"), link); err != nil {
+		t.Skipf("symlinks not supported here: %v", err)
+	}
+	if err := WriteFileAtomic(link, []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s is no longer a symlink: %v, %v", link, fi, err)
+	}
+	if data, _ := os.ReadFile(real); string(data) != "new\n" {
+		t.Errorf("target content: got %q", data)
+	}
+	if fi, _ := os.Stat(real); fi.Mode().Perm() != 0640 {
+		t.Errorf("target mode: got %v, want 0640", fi.Mode().Perm())
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package hba
+
+import (
+	"os"
+	"syscall"
+)
+
+// syncDir flushes directory metadata (such as a rename) to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// fileOwner returns the uid and gid of a file.
+func fileOwner(fi os.FileInfo) (uid, gid int) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1
+	}
+	return int(st.Uid), int(st.Gid)
+}
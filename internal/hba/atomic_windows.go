@@ -0,0 +1,12 @@
+//go:build windows
+
+package hba
+
+import "os"
+
+// syncDir is a no-op on Windows, where directories cannot be opened for syncing; the rename is
+// made durable by the file system.
+func syncDir(string) error { return nil }
+
+// fileOwner is not available on Windows.
+func fileOwner(os.FileInfo) (uid, gid int) { return -1, -1 }
@@ -0,0 +1,296 @@
+package hba
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupOptions controls where backups go and how many are kept. The zero value keeps every backup,
+// uncompressed, next to the file.
+type BackupOptions struct {
+	Dir      string        // directory for backups, one subdirectory per source directory; empty: the directory of the file
+	Keep     int           // PruneBackups keeps at most this many backups per file; 0: no limit
+	MaxAge   time.Duration // PruneBackups removes backups older than this; 0: no limit
+	Compress bool          // gzip new backups (name ends in .gz)
+}
+
+// backupTimeFormat is the timestamp in backup names, in UTC with nanosecond resolution so that
+// quick successive edits get distinct, ordered names. legacyBackupTimeFormat (local time, seconds)
+// is still recognized.
+const (
+	backupTimeFormat       = "20060102-150405.000000000Z"
+	legacyBackupTimeFormat = "20060102-150405"
+)
+
+// checksumSuffix is appended to a backup's name for its SHA-256 sidecar, in sha256sum format.
+const checksumSuffix = ".sha256"
+
+// sourcePrefix starts the comment line of a checksum file that names the file backed up
+// (sha256sum -c skips lines starting with '#').
+const sourcePrefix = "# source: "
+
+// ErrNoChecksum is returned by BackupFile.Verify for a backup without a checksum file (made by an
+// older hbactl).
+var ErrNoChecksum = errors.New("no checksum file")
+
+// ErrChecksumMismatch is returned (wrapped) by BackupFile.Verify when a backup does not match its
+// checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch (the backup is corrupt or was modified)")
+
+// BackupFile is a backup of a file, named NAME.bak.TIMESTAMP[.gz] (or NAME.bak for the oldest
+// hbactl backups).
+type BackupFile struct {
+	Path       string
+	Time       time.Time // when the backup was taken (modification time for a plain NAME.bak)
+	Size       int64     // size on disk
+	Compressed bool
+}
+
+// Backup copies path to a new backup next to it (see BackupWith).
+func Backup(path string) (string, error) {
+	return BackupWith(path, BackupOptions{})
+}
+
+// BackupWith copies path to NAME.bak.TIMESTAMP in BackupDir(path, opts) (gzipped if opts.Compress),
+// with the mode, owner and extended attributes of path, and writes its SHA-256 and the absolute path
+// of the file to a .sha256 file beside it. Returns the backup path. Old backups are not removed: see
+// PruneBackups.
+func BackupWith(path string, opts BackupOptions) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	source, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dir := BackupDir(path, opts)
+	if opts.Dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", err
+		}
+	}
+	prefix := filepath.Join(dir, filepath.Base(path)) + ".bak."
+	suffix := ""
+	if opts.Compress {
+		suffix = ".gz"
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Name = filepath.Base(path)
+		if _, err := zw.Write(data); err != nil {
+			return "", err
+		}
+		if err := zw.Close(); err != nil {
+			return "", err
+		}
+		data = buf.Bytes()
+	}
+	// Callers hold the edit lock, so only a clock that did not move can produce an existing name.
+	t := time.Now().UTC()
+	backupPath := prefix + t.Format(backupTimeFormat) + suffix
+	for exists(backupPath) {
+		t = t.Add(time.Nanosecond)
+		backupPath = prefix + t.Format(backupTimeFormat) + suffix
+	}
+	if err := writeFileLike(backupPath, data, path, 0600); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	line := fmt.Sprintf("%s%s\n%x  %s\n", sourcePrefix, source, sum, filepath.Base(backupPath))
+	if err := writeFileLike(backupPath+checksumSuffix, []byte(line), path, 0600); err != nil {
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// Backups returns the backups of path found in BackupDir(path, opts), oldest first.
+func Backups(path string, opts BackupOptions) ([]BackupFile, error) {
+	dir := BackupDir(path, opts)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(path) + ".bak"
+	var backups []BackupFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, base) || strings.HasSuffix(name, checksumSuffix) {
+			continue
+		}
+		b := BackupFile{Path: filepath.Join(dir, name)}
+		stamp := strings.TrimPrefix(name, base)
+		if b.Compressed = strings.HasSuffix(stamp, ".gz"); b.Compressed {
+			stamp = strings.TrimSuffix(stamp, ".gz")
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue // removed meanwhile
+		}
+		b.Size = fi.Size()
+		switch {
+		case stamp == "":
+			b.Time = fi.ModTime()
+		case stamp[0] != '.':
+			continue // another file's backup, e.g. pg_hba.conf.bak2
+		default:
+			if b.Time, err = time.Parse(backupTimeFormat, stamp[1:]); err != nil {
+				if b.Time, err = time.ParseInLocation(legacyBackupTimeFormat, stamp[1:], time.Local); err != nil {
+					continue
+				}
+			}
+		}
+		backups = append(backups, b)
+	}
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+	return backups, nil
+}
+
+// PruneBackups removes the backups of path beyond opts.Keep or older than opts.MaxAge, with their
+// checksum files. The newest backup is always kept. Returns the removed backups.
+func PruneBackups(path string, opts BackupOptions) ([]string, error) {
+	if opts.Keep <= 0 && opts.MaxAge <= 0 {
+		return nil, nil
+	}
+	backups, err := Backups(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var removed []string
+	var errs []error
+	for i := len(backups) - 2; i >= 0; i-- {
+		newer := len(backups) - 1 - i
+		tooMany := opts.Keep > 0 && newer >= opts.Keep
+		tooOld := opts.MaxAge > 0 && now.Sub(backups[i].Time) > opts.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		b := backups[i].Path
+		if err := os.Remove(b); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.Remove(b + checksumSuffix); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+		removed = append(removed, b)
+	}
+	return removed, errors.Join(errs...)
+}
+
+// OpenBackup returns the backup at path (e.g. as recorded by a journal). Its Time is the file's
+// modification time.
+func OpenBackup(path string) (BackupFile, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	return BackupFile{Path: path, Time: fi.ModTime(), Size: fi.Size(), Compressed: strings.HasSuffix(path, ".gz")}, nil
+}
+
+// Verify checks the backup against its SHA-256 file. It returns ErrNoChecksum if there is none and
+// an error wrapping ErrChecksumMismatch if it does not match.
+func (b BackupFile) Verify() error {
+	lines, err := b.checksumLines()
+	if err != nil {
+		return err
+	}
+	var want string
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			want, _, _ = strings.Cut(strings.TrimSpace(line), " ")
+			break
+		}
+	}
+	data, err := os.ReadFile(b.Path)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, want) {
+		return fmt.Errorf("%s: %w", b.Path, ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// Source returns the absolute path of the file the backup was taken of, as recorded in its checksum
+// file. It is empty for a backup made by an older hbactl; the error is ErrNoChecksum if there is no
+// checksum file.
+func (b BackupFile) Source() (string, error) {
+	lines, err := b.checksumLines()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range lines {
+		if source, ok := strings.CutPrefix(line, sourcePrefix); ok {
+			return source, nil
+		}
+	}
+	return "", nil
+}
+
+// checksumLines returns the lines of the backup's checksum file, or ErrNoChecksum.
+func (b BackupFile) checksumLines() ([]string, error) {
+	data, err := os.ReadFile(b.Path + checksumSuffix)
+	if os.IsNotExist(err) {
+		return nil, ErrNoChecksum
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n"), nil
+}
+
+// Read returns the content of the backup, decompressed.
+func (b BackupFile) Read() ([]byte, error) {
+	data, err := os.ReadFile(b.Path)
+	if err != nil || !b.Compressed {
+		return data, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Path, err)
+	}
+	defer zr.Close()
+	data, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Path, err)
+	}
+	return data, nil
+}
+
+// BackupDir returns the directory holding the backups of path: the directory of path or, with
+// opts.Dir, a subdirectory of it named after the absolute directory of path (e.g.
+// DIR/etc/postgresql/16/main), so that files of the same name from several clusters or included
+// directories do not share, and prune, each other's backups.
+func BackupDir(path string, opts BackupOptions) string {
+	if opts.Dir == "" {
+		return filepath.Dir(path)
+	}
+	dir := filepath.Dir(path)
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	// A volume name (C: on Windows) cannot appear inside a path: keep it as a plain directory name.
+	vol := filepath.VolumeName(dir)
+	return filepath.Join(opts.Dir, strings.ReplaceAll(vol, ":", ""), dir[len(vol):])
+}
+
+// exists reports whether path exists.
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
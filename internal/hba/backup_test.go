@@ -0,0 +1,192 @@
+package hba
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupWith_distinctNames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pg_hba.conf")
+	writeTestFile(t, path, "local all all peer\n")
+
+	seen := map[string]bool{}
+	for range 3 {
+		b, err := Backup(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[b] {
+			t.Fatalf("backup %s made twice", b)
+		}
+		seen[b] = true
+	}
+	backups, err := Backups(path, BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("got %d backups, want 3", len(backups))
+	}
+	for _, b := range backups {
+		if err := b.Verify(); err != nil {
+			t.Errorf("Verify(%s): %v", b.Path, err)
+		}
+	}
+}
+
+func TestBackupWith_dirAndGzip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pg_hba.conf")
+	content := "local all all peer\nhost all all 10.0.0.0/8 scram-sha-256\n"
+	writeTestFile(t, path, content)
+
+	opts := BackupOptions{Dir: filepath.Join(dir, "backups"), Compress: true}
+	b, err := BackupWith(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(opts.Dir, dir); filepath.Dir(b) != want || !strings.HasSuffix(b, ".gz") {
+		t.Errorf("backup path: got %s, want it in %s", b, want)
+	}
+	backups, err := Backups(path, opts)
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("Backups: %v, %v", backups, err)
+	}
+	if !backups[0].Compressed {
+		t.Error("backup not reported as compressed")
+	}
+	data, err := backups[0].Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("Read: got %q, want %q", data, content)
+	}
+	if err := backups[0].Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	// Corrupting the backup is detected.
+	if err := os.WriteFile(b, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := backups[0].Verify(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Verify of a corrupt backup: got %v", err)
+	}
+	if err := os.Remove(b + checksumSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if err := backups[0].Verify(); !errors.Is(err, ErrNoChecksum) {
+		t.Errorf("Verify without checksum: got %v, want ErrNoChecksum", err)
+	}
+}
+
+func TestBackupWith_sharedDir(t *testing.T) {
+	dir := t.TempDir()
+	opts := BackupOptions{Dir: filepath.Join(dir, "backups"), Keep: 1}
+	a, b := filepath.Join(dir, "15", "main", "pg_hba.conf"), filepath.Join(dir, "16", "main", "pg_hba.conf")
+	for _, p := range []string{a, b} {
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, p, "local all all peer\n")
+	}
+	backupA, err := BackupWith(a, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := BackupWith(b, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if removed, err := PruneBackups(b, opts); err != nil || len(removed) != 1 {
+		t.Fatalf("PruneBackups: removed %v, %v", removed, err)
+	}
+	backups, err := Backups(a, opts)
+	if err != nil || len(backups) != 1 || backups[0].Path != backupA {
+		t.Fatalf("backups of %s: got %v, %v; want only %s", a, backups, err, backupA)
+	}
+	if source, err := backups[0].Source(); err != nil || source != a {
+		t.Errorf("Source: got %q, %v; want %q", source, err, a)
+	}
+	if err := backups[0].Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestBackups_legacyNames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pg_hba.conf")
+	writeTestFile(t, path, "local all all peer\n")
+	writeTestFile(t, path+".bak", "old\n")
+	writeTestFile(t, path+".bak.20240102-030405", "older\n")
+	writeTestFile(t, path+".bak2", "not a backup\n")
+
+	backups, err := Backups(path, BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("got %d backups, want 2: %v", len(backups), backups)
+	}
+	if want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local); !backups[0].Time.Equal(want) {
+		t.Errorf("legacy timestamp: got %v, want %v", backups[0].Time, want)
+	}
+	if backups[1].Path != path+".bak" {
+		t.Errorf("newest backup: got %s, want %s.bak", backups[1].Path, path)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pg_hba.conf")
+	writeTestFile(t, path, "local all all peer\n")
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(backupTimeFormat)
+	writeTestFile(t, path+".bak."+old, "old\n")
+	writeTestFile(t, path+".bak."+old+checksumSuffix, "x  y\n")
+	for range 3 {
+		if _, err := Backup(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := PruneBackups(path, BackupOptions{MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != path+".bak."+old {
+		t.Errorf("prune by age removed %v", removed)
+	}
+	if exists(path + ".bak." + old + checksumSuffix) {
+		t.Error("checksum file of a pruned backup was left behind")
+	}
+
+	removed, err = PruneBackups(path, BackupOptions{Keep: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("prune by count removed %d backups, want 2", len(removed))
+	}
+	if backups, _ := Backups(path, BackupOptions{}); len(backups) != 1 {
+		t.Errorf("%d backups left, want 1", len(backups))
+	}
+
+	// The newest backup is kept even when it is too old.
+	if removed, _ := PruneBackups(path, BackupOptions{MaxAge: time.Nanosecond}); len(removed) != 0 {
+		t.Errorf("newest backup pruned: %v", removed)
+	}
+}
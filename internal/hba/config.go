@@ -0,0 +1,359 @@
+package hba
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Config is a pg_hba.conf together with every file it includes (include, include_if_exists,
+// include_dir). Rules are presented in effective evaluation order: the rules of an included file
+// take the place of the directive that includes it.
+type Config struct {
+	Root *Document   // the top-level file
+	Docs []*Document // every loaded file, Root first; a file included twice is loaded once
+
+	// Version is the target PostgreSQL version. Before 16, include directives are not followed
+	// (PostgreSQL would reject them).
+	Version Version
+
+	byPath    map[string]*Document
+	includes  map[*Node][]*Document // directive node -> documents it pulls in
+	nameFiles map[string]*NameFile  // @file references, by resolved path
+}
+
+// LoadConfig parses path and follows its include directives. Relative include paths are resolved
+// against the directory of the including file. An include cycle is an error.
+func LoadConfig(path string) (*Config, error) {
+	return LoadConfigVersion(path, 0)
+}
+
+// LoadConfigVersion is like LoadConfig for a given target PostgreSQL version (zero: latest).
+func LoadConfigVersion(path string, v Version) (*Config, error) {
+	c := &Config{Version: v, byPath: map[string]*Document{}, includes: map[*Node][]*Document{}, nameFiles: map[string]*NameFile{}}
+	root, err := c.load(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.Root = root
+	return c, nil
+}
+
+// ReadConfig reads a configuration from r (e.g. standard input) for the target version v. name is
+// used as the Path of the root document. Include directives are not followed: there is no
+// directory they could be resolved against.
+func ReadConfig(r io.Reader, name string, v Version) (*Config, error) {
+	doc, err := ReadDocument(r, name)
+	if err != nil {
+		return nil, err
+	}
+	doc.Version = v
+	c := &Config{Root: doc, Docs: []*Document{doc}, Version: v, byPath: map[string]*Document{}, includes: map[*Node][]*Document{}, nameFiles: map[string]*NameFile{}}
+	if abs, err := filepath.Abs(name); err == nil {
+		c.byPath[abs] = doc
+	}
+	return c, nil
+}
+
+// load parses path (once) and, recursively, the files it includes. stack holds the absolute paths
+// of the files currently being included, for cycle detection.
+func (c *Config) load(path string, stack []string) (*Document, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range stack {
+		if p == abs {
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(append(stack[i:], abs), " -> "))
+		}
+	}
+	if doc, ok := c.byPath[abs]; ok {
+		return doc, nil
+	}
+	doc, err := ParseDocument(path)
+	if err != nil {
+		return nil, err
+	}
+	doc.Version = c.Version
+	c.byPath[abs] = doc
+	c.Docs = append(c.Docs, doc)
+	if !c.Version.Supports(FeatureInclude) {
+		return doc, nil
+	}
+
+	stack = append(stack, abs)
+	for pos, n := range doc.nodes {
+		if n.Kind != NodeDirective {
+			continue
+		}
+		files, err := resolveDirective(filepath.Dir(path), n.Directive)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, doc.LineOf(pos), err)
+		}
+		for _, f := range files {
+			inc, err := c.load(f, stack)
+			if err != nil {
+				return nil, err
+			}
+			c.includes[n] = append(c.includes[n], inc)
+		}
+	}
+	return doc, nil
+}
+
+// resolveDirective returns the files a directive refers to, relative to dir.
+// include_if_exists yields nothing for a missing file; include_dir yields the *.conf files of the
+// directory in name order, skipping hidden files.
+func resolveDirective(dir string, d Directive) ([]string, error) {
+	target := d.Path
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(dir, target)
+	}
+	switch d.Keyword {
+	case "include":
+		return []string{target}, nil
+	case "include_if_exists":
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			return nil, nil
+		}
+		return []string{target}, nil
+	case "include_dir":
+		entries, err := os.ReadDir(target)
+		if err != nil {
+			return nil, fmt.Errorf("include_dir %s: %w", d.Path, err)
+		}
+		var files []string
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".conf") {
+				continue
+			}
+			files = append(files, filepath.Join(target, name))
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+	return nil, fmt.Errorf("unknown directive %q", d.Keyword)
+}
+
+// Included returns the documents pulled in by the directive node n (nil if none).
+func (c *Config) Included(n *Node) []*Document { return c.includes[n] }
+
+// Document returns the loaded document for path, or nil if path is not part of the configuration.
+func (c *Config) Document(path string) *Document {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	return c.byPath[abs]
+}
+
+// Changed returns the documents that have been modified, in load order.
+func (c *Config) Changed() []*Document {
+	var docs []*Document
+	for _, d := range c.Docs {
+		if d.Changed() {
+			docs = append(docs, d)
+		}
+	}
+	return docs
+}
+
+// CheckUnchanged returns a ConflictError for the first modified document (or edited name file)
+// whose file changed on disk since it was read.
+func (c *Config) CheckUnchanged() error {
+	for _, d := range c.Changed() {
+		if err := d.CheckUnchanged(); err != nil {
+			return err
+		}
+	}
+	for _, f := range c.nameFiles {
+		if f.Changed() {
+			if err := f.read.check(f.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Rules returns all rules in effective evaluation order. Index is 1-based across all files;
+// File, LineNo, EndLineNo and Pos locate the rule in the document it lives in. Databases and Users
+// hold the columns with @file references replaced by the names listed in the referenced files.
+func (c *Config) Rules() []RuleWithLine {
+	var result []RuleWithLine
+	c.walk(c.Root, &result)
+	return result
+}
+
+func (c *Config) walk(d *Document, result *[]RuleWithLine) {
+	d.eachRule(func(x RuleWithLine) {
+		x.Index = len(*result) + 1
+		x.Databases, _ = c.expand(x.Rule.Databases(), x.File, databaseKeywords, nil)
+		x.Users, _ = c.expand(x.Rule.Users(), x.File, userKeywords, nil)
+		*result = append(*result, x)
+	}, func(n *Node) {
+		for _, inc := range c.includes[n] {
+			c.walk(inc, result)
+		}
+	})
+}
+
+// Rule returns the rule with the given 1-based effective index.
+func (c *Config) Rule(index int) (RuleWithLine, bool) {
+	for _, x := range c.Rules() {
+		if x.Index == index {
+			return x, true
+		}
+	}
+	return RuleWithLine{}, false
+}
+
+// Node returns the node holding the rule x, which identifies the rule across later edits (see Find).
+func (c *Config) Node(x RuleWithLine) *Node {
+	d := c.Document(x.File)
+	if d == nil || x.Pos < 0 || x.Pos >= len(d.nodes) {
+		return nil
+	}
+	return d.nodes[x.Pos]
+}
+
+// Find returns the rule held by node n as it is now (index and position may have changed since
+// n was obtained), or false if it was removed.
+func (c *Config) Find(n *Node) (RuleWithLine, bool) {
+	for _, x := range c.Rules() {
+		if c.Node(x) == n {
+			return x, true
+		}
+	}
+	return RuleWithLine{}, false
+}
+
+// MoveRule moves the rule x, with the comment lines directly above it (see Document.CommentStart),
+// just before the rule target and its comment lines, or just after target if after is set. Both
+// must live in the same file.
+func (c *Config) MoveRule(x, target RuleWithLine, after bool) error {
+	d := c.Document(x.File)
+	if d == nil || d != c.Document(target.File) {
+		return fmt.Errorf("rule #%d (%s) and rule #%d (%s) are in different files; rules can only be moved within a file", x.Index, x.File, target.Index, target.File)
+	}
+	if x.Pos == target.Pos {
+		return nil
+	}
+	start, end := d.CommentStart(x.Pos), x.Pos+1
+	to := d.CommentStart(target.Pos)
+	if after {
+		to = target.Pos + 1
+	}
+	// Already there, give or take blank lines: leave the file alone.
+	if (to >= end && d.blank(end, to)) || (to <= start && d.blank(to, start)) {
+		return nil
+	}
+	return d.MoveRange(start, end, to)
+}
+
+// Remove deletes the given rules from the documents they live in.
+func (c *Config) Remove(rules []RuleWithLine) {
+	byDoc := make(map[*Document][]int)
+	for _, x := range rules {
+		if d := c.Document(x.File); d != nil {
+			byDoc[d] = append(byDoc[d], x.Pos)
+		}
+	}
+	for d, positions := range byDoc {
+		d.Remove(positions...)
+	}
+}
+
+// ReplaceRule replaces the rule x with r in the document it lives in.
+func (c *Config) ReplaceRule(x RuleWithLine, r Rule) error {
+	d := c.Document(x.File)
+	if d == nil {
+		return fmt.Errorf("%s is not part of the configuration", x.File)
+	}
+	return d.ReplaceRule(x.Pos, r)
+}
+
+// AppendRule appends r at the end of the top-level file.
+func (c *Config) AppendRule(r Rule) (*Document, error) {
+	return c.Root, c.Root.AppendRule(r)
+}
+
+// InsertRuleAfterUser inserts r right after the last rule (in evaluation order) that lists
+// afterUser, in the file that rule lives in. If there is none, r is appended to the top-level file.
+func (c *Config) InsertRuleAfterUser(r Rule, afterUser string) (*Document, error) {
+	var last *RuleWithLine
+	if afterUser != "" {
+		rules := c.Rules()
+		for i := range rules {
+			if rules[i].MatchesUser(afterUser, "") {
+				last = &rules[i]
+			}
+		}
+	}
+	if last == nil {
+		return c.AppendRule(r)
+	}
+	d := c.Document(last.File)
+	return d, d.InsertRule(last.Pos+1, r)
+}
+
+// InsertRuleBefore inserts r just before the rule x and the comment lines directly above it (see
+// Document.CommentStart), in the file x lives in. It returns the inserted rule.
+func (c *Config) InsertRuleBefore(r Rule, x RuleWithLine) (RuleWithLine, error) {
+	d := c.Document(x.File)
+	if d == nil {
+		return RuleWithLine{}, fmt.Errorf("%s is not part of the configuration", x.File)
+	}
+	return c.insertRule(d, d.CommentStart(x.Pos), r)
+}
+
+// InsertRuleAfter inserts r just after the rule x, in the file x lives in. It returns the inserted rule.
+func (c *Config) InsertRuleAfter(r Rule, x RuleWithLine) (RuleWithLine, error) {
+	d := c.Document(x.File)
+	if d == nil {
+		return RuleWithLine{}, fmt.Errorf("%s is not part of the configuration", x.File)
+	}
+	return c.insertRule(d, x.Pos+1, r)
+}
+
+// InsertRuleAtLine inserts r before the 1-based line lineNo of the file path, or at its end when
+// lineNo is one past its last line. It returns the inserted rule.
+func (c *Config) InsertRuleAtLine(r Rule, path string, lineNo int) (RuleWithLine, error) {
+	d := c.Document(path)
+	if d == nil {
+		return RuleWithLine{}, fmt.Errorf("%s is not part of the configuration", path)
+	}
+	pos := d.Len()
+	if lineNo != d.LineCount()+1 {
+		var ok bool
+		if pos, ok = d.PosOfLine(lineNo); !ok {
+			return RuleWithLine{}, fmt.Errorf("%s has no line %d (it has %d)", d.Path, lineNo, d.LineCount())
+		}
+		if start := d.LineOf(pos); start != lineNo {
+			return RuleWithLine{}, fmt.Errorf("line %d of %s is inside the rule continued from line %d", lineNo, d.Path, start)
+		}
+	}
+	return c.insertRule(d, pos, r)
+}
+
+// insertRule inserts r at position pos of d and returns it as a rule of the configuration.
+func (c *Config) insertRule(d *Document, pos int, r Rule) (RuleWithLine, error) {
+	if err := d.InsertRule(pos, r); err != nil {
+		return RuleWithLine{}, err
+	}
+	x, ok := c.Find(d.nodes[pos])
+	if !ok {
+		// d is not reached from the top-level file (e.g. an include_if_exists that was skipped).
+		return RuleWithLine{}, fmt.Errorf("%s is not included by the configuration", d.Path)
+	}
+	return x, nil
+}
+
+// NameFile returns the name list file referenced as path (see References), read through the Config cache.
+func (c *Config) NameFile(path string) (*NameFile, error) {
+	return c.nameFile(path)
+}
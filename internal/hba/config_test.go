@@ -0,0 +1,234 @@
+package hba
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadConfig_evaluationOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"pg_hba.conf":       "local all a trust\ninclude extra.conf\ninclude_if_exists missing.conf\ninclude_dir conf.d\nlocal all z trust\n",
+		"extra.conf":        "# extra\nlocal all b trust\n",
+		"conf.d/20-d.conf":  "local all d trust\n",
+		"conf.d/10-c.conf":  "local all c trust\n",
+		"conf.d/.hidden":    "local all hidden trust\n",
+		"conf.d/notes.txt":  "local all txt trust\n",
+		"conf.d/sub/x.conf": "local all sub trust\n",
+	})
+	cfg, err := LoadConfig(filepath.Join(dir, "pg_hba.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var users []string
+	for _, x := range cfg.Rules() {
+		users = append(users, x.Rule.User)
+	}
+	if got, want := strings.Join(users, ","), "a,b,c,d,z"; got != want {
+		t.Errorf("order: got %s, want %s", got, want)
+	}
+	b := cfg.Rules()[1]
+	if filepath.Base(b.File) != "extra.conf" || b.LineNo != 2 || b.Index != 2 || b.Pos != 1 {
+		t.Errorf("included rule location: %+v", b)
+	}
+	if len(cfg.Docs) != 4 {
+		t.Errorf("got %d documents, want 4", len(cfg.Docs))
+	}
+}
+
+func TestLoadConfig_errors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"missing.conf": "include nope.conf\n",
+		"a.conf":       "include b.conf\n",
+		"b.conf":       "include a.conf\n",
+	})
+	if _, err := LoadConfig(filepath.Join(dir, "missing.conf")); err == nil {
+		t.Error("include of missing file should fail")
+	}
+	_, err := LoadConfig(filepath.Join(dir, "a.conf"))
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("cycle: got %v", err)
+	}
+}
+
+func TestConfig_editsTargetOwningFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"pg_hba.conf": "local all a trust\ninclude extra.conf\nlocal all z trust\n",
+		"extra.conf":  "local all app trust\nlocal all b trust\n",
+	})
+	cfg, err := LoadConfig(filepath.Join(dir, "pg_hba.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := cfg.InsertRuleAfterUser(Rule{Type: "local", Database: "db", User: "app", Method: "peer"}, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(doc.Path) != "extra.conf" {
+		t.Errorf("inserted into %s, want extra.conf", doc.Path)
+	}
+	x, ok := cfg.Rule(5)
+	if !ok || x.Rule.User != "z" {
+		t.Fatalf("Rule(5) = %+v, %v", x, ok)
+	}
+	cfg.Remove([]RuleWithLine{x})
+	changed := cfg.Changed()
+	if len(changed) != 2 {
+		t.Fatalf("got %d changed documents, want 2", len(changed))
+	}
+	if got, want := string(cfg.Root.Bytes()), "local all a trust\ninclude extra.conf\n"; got != want {
+		t.Errorf("root: got %q, want %q", got, want)
+	}
+	if got, want := string(changed[1].Bytes()), "local all app trust\nlocal db  app peer\nlocal all b trust\n"; got != want {
+		t.Errorf("extra.conf: got %q, want %q", got, want)
+	}
+}
+
+func TestConfig_insertRulePositions(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"pg_hba.conf": "# header\n\nlocal all a trust\n# catch-all\nhost all all 0.0.0.0/0 reject\ninclude extra.conf\n",
+		"extra.conf":  "local all b trust\n",
+	})
+	r := Rule{Type: "local", Database: "all", User: "new", Method: "peer"}
+	for _, tc := range []struct {
+		name   string
+		insert func(cfg *Config) (RuleWithLine, error)
+		index  int
+		line   int
+		file   string
+	}{
+		{"before a rule goes above its comment", func(cfg *Config) (RuleWithLine, error) {
+			x, _ := cfg.Rule(2)
+			return cfg.InsertRuleBefore(r, x)
+		}, 2, 4, "pg_hba.conf"},
+		{"after a rule", func(cfg *Config) (RuleWithLine, error) {
+			x, _ := cfg.Rule(1)
+			return cfg.InsertRuleAfter(r, x)
+		}, 2, 4, "pg_hba.conf"},
+		{"after an included rule", func(cfg *Config) (RuleWithLine, error) {
+			x, _ := cfg.Rule(3)
+			return cfg.InsertRuleAfter(r, x)
+		}, 4, 2, "extra.conf"},
+		{"at a line", func(cfg *Config) (RuleWithLine, error) {
+			return cfg.InsertRuleAtLine(r, cfg.Root.Path, 2)
+		}, 1, 2, "pg_hba.conf"},
+		{"after the last line", func(cfg *Config) (RuleWithLine, error) {
+			return cfg.InsertRuleAtLine(r, cfg.Root.Path, 7)
+		}, 4, 7, "pg_hba.conf"},
+	} {
+		cfg, err := LoadConfig(filepath.Join(dir, "pg_hba.conf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := tc.insert(cfg)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if x.Index != tc.index || x.LineNo != tc.line || filepath.Base(x.File) != tc.file || x.Rule.User != "new" {
+			t.Errorf("%s: got rule #%d at %s:%d, want #%d at %s:%d", tc.name, x.Index, filepath.Base(x.File), x.LineNo, tc.index, tc.file, tc.line)
+		}
+	}
+
+	cfg, err := LoadConfig(filepath.Join(dir, "pg_hba.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.InsertRuleAtLine(r, cfg.Root.Path, 8); err == nil {
+		t.Error("InsertRuleAtLine past the end should fail")
+	}
+}
+
+func TestConfig_moveRuleWithComments(t *testing.T) {
+	const conf = "# header\n\nlocal all a trust\n# b: ops\n# (two lines)\nlocal all b trust\n\n# catch-all\nhost all all 0.0.0.0/0 reject\n"
+	for _, tc := range []struct {
+		name     string
+		from, to int
+		after    bool
+		want     string
+	}{
+		{"up, before a rule without comment", 2, 1, false,
+			"# header\n\n# b: ops\n# (two lines)\nlocal all b trust\nlocal all a trust\n\n# catch-all\nhost all all 0.0.0.0/0 reject\n"},
+		{"down, after a rule", 2, 3, true,
+			"# header\n\nlocal all a trust\n\n# catch-all\nhost all all 0.0.0.0/0 reject\n# b: ops\n# (two lines)\nlocal all b trust\n"},
+		{"down, before a rule goes above its comment", 1, 3, false,
+			"# header\n\n# b: ops\n# (two lines)\nlocal all b trust\n\nlocal all a trust\n# catch-all\nhost all all 0.0.0.0/0 reject\n"},
+		{"already in place", 2, 3, false, conf},
+	} {
+		cfg, err := ReadConfig(strings.NewReader(conf), "pg_hba.conf", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, _ := cfg.Rule(tc.from)
+		target, _ := cfg.Rule(tc.to)
+		if err := cfg.MoveRule(x, target, tc.after); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := string(cfg.Root.Bytes()); got != tc.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestConfig_moveRuleBelowHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name, conf, want string
+	}{
+		{"column header",
+			"# TYPE  DATABASE  USER  ADDRESS  METHOD\nlocal all a trust\nhost all all 0.0.0.0/0 reject\n",
+			"# TYPE  DATABASE  USER  ADDRESS  METHOD\nhost all all 0.0.0.0/0 reject\nlocal all a trust\n"},
+		{"comment under the column header moves with the rule",
+			"# TYPE  DATABASE  USER  ADDRESS  METHOD\n# a: ops\nlocal all a trust\nhost all all 0.0.0.0/0 reject\n",
+			"# TYPE  DATABASE  USER  ADDRESS  METHOD\nhost all all 0.0.0.0/0 reject\n# a: ops\nlocal all a trust\n"},
+		{"file header",
+			"# pg_hba.conf for db1\n# managed by hbactl\nlocal all a trust\nhost all all 0.0.0.0/0 reject\n",
+			"# pg_hba.conf for db1\n# managed by hbactl\nhost all all 0.0.0.0/0 reject\nlocal all a trust\n"},
+	} {
+		// Moving the last rule up to the first, and the first down below the last, give the same file.
+		for _, move := range []struct{ from, to int }{{2, 1}, {1, 2}} {
+			cfg, err := ReadConfig(strings.NewReader(tc.conf), "pg_hba.conf", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			x, _ := cfg.Rule(move.from)
+			target, _ := cfg.Rule(move.to)
+			if err := cfg.MoveRule(x, target, move.from < move.to); err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if got := string(cfg.Root.Bytes()); got != tc.want {
+				t.Errorf("%s, #%d to #%d:\ngot  %q\nwant %q", tc.name, move.from, move.to, got, tc.want)
+			}
+		}
+	}
+
+	cfg, err := ReadConfig(strings.NewReader("# TYPE  DATABASE  USER  ADDRESS  METHOD\nlocal all a trust\n"), "pg_hba.conf", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _ := cfg.Rule(1)
+	if _, err := cfg.InsertRuleBefore(Rule{Type: "local", Database: "all", User: "new", Method: "peer"}, x); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(cfg.Root.Bytes()), "# TYPE  DATABASE  USER  ADDRESS  METHOD\nlocal all new peer\nlocal all a trust\n"; got != want {
+		t.Errorf("insert before rule #1:\ngot  %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,48 @@
+package hba
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diagnostic reports a line that could not be parsed. Such lines are kept in the document (and
+// written back unchanged) but contribute no rule.
+type Diagnostic struct {
+	File    string
+	Line    int    // 1-based line number (first line of a continued rule)
+	Column  int    // 1-based column in the line, continuations joined
+	Message string // e.g. `invalid connection type "hots"`
+	Text    string // offending text as written
+}
+
+// String formats the diagnostic as file:line:column: message.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+// Diagnostics returns one Diagnostic per line of the document that could not be parsed, or that
+// uses syntax (include directives, continuations) that PostgreSQL d.Version does not accept, in file order.
+func (d *Document) Diagnostics() []Diagnostic { return d.check(false) }
+
+// Diagnostics returns the diagnostics of every loaded file, in load order.
+func (c *Config) Diagnostics() []Diagnostic {
+	var diags []Diagnostic
+	for _, d := range c.Docs {
+		diags = append(diags, d.Diagnostics()...)
+	}
+	return diags
+}
+
+// ParseFileWithDiagnostics is like ParseFileWithLineNumbers, but also returns the lines that could not be parsed.
+func ParseFileWithDiagnostics(path string) ([]RuleWithLine, []Diagnostic, error) {
+	doc, err := ParseDocument(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc.Rules(), doc.Diagnostics(), nil
+}
+
+// diagnosticText returns the text of n as shown in a Diagnostic.
+func diagnosticText(n *Node) string {
+	return strings.TrimSpace(strings.TrimSuffix(n.Text(), "\r"))
+}
@@ -0,0 +1,44 @@
+package hba
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFileWithDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pg_hba.conf")
+	content := "local all all trust\n" +
+		"hots all all 10.0.0.1/32 md5\n" +
+		"host all app\n" +
+		"  local all \\\n  \"oops peer\n" +
+		"include\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rules, diags, err := ParseFileWithDiagnostics(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 {
+		t.Errorf("got %d rules, want 1", len(rules))
+	}
+	want := []Diagnostic{
+		{File: path, Line: 2, Column: 1, Message: `invalid connection type "hots"`, Text: "hots all all 10.0.0.1/32 md5"},
+		{File: path, Line: 3, Column: 13, Message: "end-of-line before IP address specification", Text: "host all app"},
+		{File: path, Line: 4, Column: 15, Message: "unterminated quoted string", Text: "local all \\\n  \"oops peer"},
+		{File: path, Line: 6, Column: 8, Message: "missing file name after include", Text: "include"},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d: %+v", len(diags), len(want), diags)
+	}
+	for i := range want {
+		if diags[i] != want[i] {
+			t.Errorf("diagnostic %d: got %+v, want %+v", i, diags[i], want[i])
+		}
+	}
+	if got := diags[0].String(); got != path+":2:1: invalid connection type \"hots\"" {
+		t.Errorf("String: got %s", got)
+	}
+}
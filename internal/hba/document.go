@@ -0,0 +1,530 @@
+package hba
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// NodeKind identifies what a node (one entry of a Document) holds.
+type NodeKind int
+
+const (
+	NodeBlank     NodeKind = iota // empty or whitespace-only line
+	NodeComment                   // line with only a comment
+	NodeRule                      // local/host* record
+	NodeDirective                 // include, include_if_exists, include_dir
+	NodeInvalid                   // line that could not be parsed; kept verbatim
+)
+
+// String returns a short name for the kind (e.g. "rule").
+func (k NodeKind) String() string {
+	switch k {
+	case NodeBlank:
+		return "blank"
+	case NodeComment:
+		return "comment"
+	case NodeRule:
+		return "rule"
+	case NodeDirective:
+		return "directive"
+	case NodeInvalid:
+		return "invalid"
+	}
+	return fmt.Sprintf("NodeKind(%d)", int(k))
+}
+
+// Directive is an include-style line: include, include_if_exists or include_dir followed by a path.
+type Directive struct {
+	Keyword string // include, include_if_exists, include_dir
+	Path    string // as written in the file (may be relative)
+}
+
+// directiveKeywords are the keywords that introduce a Directive.
+var directiveKeywords = map[string]bool{
+	"include":           true,
+	"include_if_exists": true,
+	"include_dir":       true,
+}
+
+// Node is one entry of a Document. Nodes read from a file keep their original text and are
+// written back unchanged unless replaced; nodes created in memory are rendered from Rule.
+type Node struct {
+	Kind      NodeKind
+	Rule      Rule      // set when Kind == NodeRule
+	Directive Directive // set when Kind == NodeDirective
+
+	raw      string  // original text without trailing newline; empty when the node must be rendered
+	comment  string  // inline trailing comment ("# ..."), kept when the rule is replaced
+	template *layout // column layout used when rendering
+	problem  string  // why the line could not be parsed (Kind == NodeInvalid)
+	column   int     // 1-based column of the problem in the logical line
+}
+
+// Raw returns the original text of the node as read from the file (empty for new or replaced nodes).
+func (n *Node) Raw() string { return n.raw }
+
+// Comment returns the inline trailing comment of a rule (e.g. "# office VPN"), or the text of a comment line.
+func (n *Node) Comment() string { return n.comment }
+
+// Lines returns the number of physical lines the node spans (more than one for continued lines).
+func (n *Node) Lines() int {
+	if n.raw == "" {
+		return 1
+	}
+	return strings.Count(n.raw, "\n") + 1
+}
+
+// Text returns the text written for the node (no trailing newline).
+func (n *Node) Text() string {
+	if n.raw != "" || n.Kind == NodeBlank {
+		return n.raw
+	}
+	switch n.Kind {
+	case NodeRule:
+		return n.template.render(n.Rule.columns(), n.comment)
+	case NodeDirective:
+		return n.Directive.Keyword + " " + quoteIfNeeded(n.Directive.Path, false)
+	}
+	return n.comment
+}
+
+// Document is a pg_hba.conf file as an ordered list of nodes (rules, comments, blanks, directives).
+// Untouched nodes are serialized byte-identically; only inserted or replaced rules are rendered,
+// aligned to the columns of a neighbouring rule.
+type Document struct {
+	Path    string
+	Version Version // target PostgreSQL version for Diagnostics and Validate (zero: latest)
+
+	nodes        []*Node
+	finalNewline bool   // original content ended with a newline
+	changed      bool   // a mutation has been applied
+	read         *stamp // content of Path when parsed (nil when not read from a file)
+}
+
+// ParseDocument reads path into a Document.
+func ParseDocument(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := parseDocument(string(data))
+	d.Path = path
+	d.read = newStamp(path, data)
+	return d, nil
+}
+
+// ReadDocument reads a Document from r. name is used as its Path (e.g. "<stdin>") in rule
+// locations and diagnostics.
+func ReadDocument(r io.Reader, name string) (*Document, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	d := parseDocument(string(data))
+	d.Path = name
+	return d, nil
+}
+
+// parseDocument splits content into lines and classifies each one. A line ending in a backslash
+// continues on the next line (PostgreSQL 16+); the physical lines form a single node.
+func parseDocument(content string) *Document {
+	d := &Document{}
+	if content == "" {
+		return d
+	}
+	if strings.HasSuffix(content, "\n") {
+		d.finalNewline = true
+		content = strings.TrimSuffix(content, "\n")
+	}
+	var raw []string
+	var logical strings.Builder
+	for _, ln := range strings.Split(content, "\n") {
+		raw = append(raw, ln)
+		text := strings.TrimSuffix(ln, "\r")
+		if strings.HasSuffix(text, "\\") {
+			logical.WriteString(strings.TrimSuffix(text, "\\"))
+			continue
+		}
+		logical.WriteString(text)
+		d.nodes = append(d.nodes, parseNode(strings.Join(raw, "\n"), logical.String()))
+		raw = raw[:0]
+		logical.Reset()
+	}
+	if len(raw) > 0 {
+		// The last line ended with a backslash: nothing left to continue with.
+		d.nodes = append(d.nodes, parseNode(strings.Join(raw, "\n"), logical.String()))
+	}
+	return d
+}
+
+// parseNode classifies one logical line. raw is the original text (several physical lines joined by
+// newlines when continued); logical is the text with continuations removed.
+func parseNode(raw, logical string) *Node {
+	n := &Node{raw: raw}
+	body, comment := splitComment(logical)
+	n.comment = comment
+	lead := len(body) - len(strings.TrimLeft(body, " \t"))
+	body = strings.TrimSpace(body)
+	switch {
+	case body == "" && comment == "":
+		n.Kind = NodeBlank
+		return n
+	case body == "":
+		n.Kind = NodeComment
+		return n
+	}
+	if d, ok := parseDirective(body); ok {
+		n.Kind = NodeDirective
+		n.Directive = d
+		return n
+	}
+	r, err := parseRule(body)
+	if err == nil {
+		n.Kind = NodeRule
+		n.Rule = r
+		n.template = layoutOf(raw, logical, r)
+		return n
+	}
+	n.Kind = NodeInvalid
+	n.problem = err.msg
+	n.column = lead + len(body) + 1
+	if offsets := fieldOffsets(body); err.field < len(offsets) {
+		n.column = lead + offsets[err.field] + 1
+	}
+	return n
+}
+
+// parseDirective parses "include path" style lines.
+func parseDirective(line string) (Directive, bool) {
+	fields := splitFields(line)
+	if len(fields) != 2 {
+		return Directive{}, false
+	}
+	kw := strings.ToLower(fields[0])
+	if !directiveKeywords[kw] {
+		return Directive{}, false
+	}
+	path, _ := unquote(fields[1])
+	return Directive{Keyword: kw, Path: path}, true
+}
+
+// Nodes returns the nodes in file order. Modify the document through its methods; changes made
+// directly to a node are not reflected in Bytes.
+func (d *Document) Nodes() []*Node { return d.nodes }
+
+// Len returns the number of nodes.
+func (d *Document) Len() int { return len(d.nodes) }
+
+// Changed reports whether the document has been modified since it was parsed.
+func (d *Document) Changed() bool { return d.changed }
+
+// LineCount returns the number of physical lines in the document.
+func (d *Document) LineCount() int {
+	total := 0
+	for _, n := range d.nodes {
+		total += n.Lines()
+	}
+	return total
+}
+
+// LineOf returns the 1-based line number at which the node at pos starts.
+func (d *Document) LineOf(pos int) int {
+	line := 1
+	for _, n := range d.nodes[:pos] {
+		line += n.Lines()
+	}
+	return line
+}
+
+// PosOfLine returns the position of the node that contains the 1-based line lineNo.
+// For a continued rule, every line of its span maps to the same node.
+func (d *Document) PosOfLine(lineNo int) (int, bool) {
+	if lineNo < 1 {
+		return 0, false
+	}
+	line := 1
+	for pos, n := range d.nodes {
+		line += n.Lines()
+		if lineNo < line {
+			return pos, true
+		}
+	}
+	return 0, false
+}
+
+// Rules returns the rules in file order with their line spans, 1-based indices and node positions.
+func (d *Document) Rules() []RuleWithLine {
+	var result []RuleWithLine
+	d.eachRule(func(x RuleWithLine) {
+		x.Index = len(result) + 1
+		result = append(result, x)
+	}, nil)
+	return result
+}
+
+// eachRule calls fn for every rule (Index unset) and onDirective, if not nil, for every directive node,
+// in file order.
+func (d *Document) eachRule(fn func(RuleWithLine), onDirective func(*Node)) {
+	line := 1
+	for pos, n := range d.nodes {
+		switch n.Kind {
+		case NodeRule:
+			fn(RuleWithLine{Rule: n.Rule, File: d.Path, LineNo: line, EndLineNo: line + n.Lines() - 1, Pos: pos})
+		case NodeDirective:
+			if onDirective != nil {
+				onDirective(n)
+			}
+		}
+		line += n.Lines()
+	}
+}
+
+// RulePos returns the node position of the rule with the given 1-based index.
+func (d *Document) RulePos(index int) (int, bool) {
+	i := 0
+	for pos, n := range d.nodes {
+		if n.Kind != NodeRule {
+			continue
+		}
+		i++
+		if i == index {
+			return pos, true
+		}
+	}
+	return 0, false
+}
+
+// CommentStart returns the position of the first of the comment lines directly above the node at
+// pos (with no blank line in between), which describe it; pos itself if there are none. A column
+// header ("# TYPE DATABASE USER ...") and the lines above it are not part of the block, nor is a
+// block that starts the file: that is the file's header.
+func (d *Document) CommentStart(pos int) int {
+	if pos <= 0 || pos > len(d.nodes) {
+		return pos
+	}
+	start := pos
+	for start > 0 && d.nodes[start-1].Kind == NodeComment {
+		if columnHeader(d.nodes[start-1].comment) {
+			return start
+		}
+		start--
+	}
+	if start == 0 {
+		return pos
+	}
+	return start
+}
+
+// columnHeader reports whether comment is a line naming the columns, like the "# TYPE  DATABASE
+// USER  ADDRESS  METHOD" of the sample pg_hba.conf.
+func columnHeader(comment string) bool {
+	fields := strings.Fields(strings.ToUpper(strings.TrimLeft(comment, "# \t")))
+	return len(fields) >= 2 && fields[0] == "TYPE" && fields[1] == "DATABASE"
+}
+
+// Insert inserts n so that it ends up at position pos (0 <= pos <= Len()).
+func (d *Document) Insert(pos int, n *Node) error {
+	if pos < 0 || pos > len(d.nodes) {
+		return fmt.Errorf("position %d out of range (document has %d nodes)", pos, len(d.nodes))
+	}
+	d.nodes = append(d.nodes, nil)
+	copy(d.nodes[pos+1:], d.nodes[pos:])
+	d.nodes[pos] = n
+	d.changed = true
+	return nil
+}
+
+// InsertRule inserts r at position pos, aligned to the columns of the nearest rule.
+func (d *Document) InsertRule(pos int, r Rule) error {
+	if r.Line() == "" {
+		return fmt.Errorf("invalid rule type %q", r.Type)
+	}
+	n := &Node{Kind: NodeRule, Rule: r, template: d.nearestLayout(pos)}
+	return d.Insert(pos, n)
+}
+
+// AppendRule adds r after the last node.
+func (d *Document) AppendRule(r Rule) error {
+	return d.InsertRule(len(d.nodes), r)
+}
+
+// InsertRuleAfterUser inserts r after the last rule that lists afterUser (see Rule.MatchesUser).
+// If afterUser is empty or no such rule exists, r is appended at the end.
+func (d *Document) InsertRuleAfterUser(r Rule, afterUser string) error {
+	pos := len(d.nodes)
+	if afterUser != "" {
+		for i, n := range d.nodes {
+			if n.Kind == NodeRule && n.Rule.MatchesUser(afterUser, "") {
+				pos = i + 1
+			}
+		}
+	}
+	return d.InsertRule(pos, r)
+}
+
+// ReplaceRule replaces the rule at node position pos with r, keeping its inline comment and column layout.
+func (d *Document) ReplaceRule(pos int, r Rule) error {
+	if pos < 0 || pos >= len(d.nodes) || d.nodes[pos].Kind != NodeRule {
+		return fmt.Errorf("no rule at position %d", pos)
+	}
+	if r.Line() == "" {
+		return fmt.Errorf("invalid rule type %q", r.Type)
+	}
+	n := d.nodes[pos]
+	n.Rule = r
+	n.raw = ""
+	d.changed = true
+	return nil
+}
+
+// Remove deletes the nodes at the given positions. Out-of-range positions are ignored.
+func (d *Document) Remove(positions ...int) {
+	drop := make(map[int]bool, len(positions))
+	for _, p := range positions {
+		if p >= 0 && p < len(d.nodes) {
+			drop[p] = true
+		}
+	}
+	if len(drop) == 0 {
+		return
+	}
+	kept := d.nodes[:0]
+	for i, n := range d.nodes {
+		if !drop[i] {
+			kept = append(kept, n)
+		}
+	}
+	d.nodes = kept
+	d.changed = true
+}
+
+// Move moves the node at from so that it ends up at position to.
+func (d *Document) Move(from, to int) error {
+	if from < 0 || from >= len(d.nodes) {
+		return fmt.Errorf("position %d out of range (document has %d nodes)", from, len(d.nodes))
+	}
+	if to < 0 || to >= len(d.nodes) {
+		return fmt.Errorf("position %d out of range (document has %d nodes)", to, len(d.nodes))
+	}
+	if from == to {
+		return nil
+	}
+	n := d.nodes[from]
+	d.nodes = append(d.nodes[:from], d.nodes[from+1:]...)
+	d.nodes = append(d.nodes, nil)
+	copy(d.nodes[to+1:], d.nodes[to:])
+	d.nodes[to] = n
+	d.changed = true
+	return nil
+}
+
+// blank reports whether the nodes at positions from to to-1 are all blank lines.
+func (d *Document) blank(from, to int) bool {
+	for _, n := range d.nodes[from:to] {
+		if n.Kind != NodeBlank {
+			return false
+		}
+	}
+	return true
+}
+
+// MoveRange moves the nodes at positions start to end-1 so that they come just before the node now
+// at position to (Len() moves them to the end). to must not fall inside the range.
+func (d *Document) MoveRange(start, end, to int) error {
+	if start < 0 || end > len(d.nodes) || start >= end {
+		return fmt.Errorf("range %d-%d out of range (document has %d nodes)", start, end, len(d.nodes))
+	}
+	if to < 0 || to > len(d.nodes) || (to > start && to < end) {
+		return fmt.Errorf("cannot move nodes %d-%d to position %d", start, end, to)
+	}
+	if to == start || to == end {
+		return nil
+	}
+	block := append([]*Node(nil), d.nodes[start:end]...)
+	rest := append(append([]*Node(nil), d.nodes[:start]...), d.nodes[end:]...)
+	if to > end {
+		to -= end - start
+	}
+	d.nodes = append(append(rest[:to:to], block...), rest[to:]...)
+	d.changed = true
+	return nil
+}
+
+// Bytes returns the serialized document. An unmodified document is returned byte-identical;
+// a modified one always ends with a newline.
+func (d *Document) Bytes() []byte {
+	var b strings.Builder
+	for i, n := range d.nodes {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(n.Text())
+	}
+	if d.finalNewline || d.changed {
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// WriteFile writes the serialized document to path atomically (see WriteFileAtomic). When path is
+// the file the document was parsed from, it returns a ConflictError instead if the file changed
+// on disk since then.
+func (d *Document) WriteFile(path string) error {
+	data := d.Bytes()
+	if path == d.Path {
+		if err := d.CheckUnchanged(); err != nil {
+			return err
+		}
+	}
+	if err := WriteFileAtomic(path, data, 0644); err != nil {
+		return err
+	}
+	if path == d.Path {
+		d.read = newStamp(path, data)
+	}
+	return nil
+}
+
+// CheckUnchanged returns a ConflictError if the file the document was parsed from changed since.
+func (d *Document) CheckUnchanged() error {
+	return d.read.check(d.Path)
+}
+
+// WriteTo writes the serialized document to w.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(d.Bytes())
+	return int64(n), err
+}
+
+// nearestLayout returns the layout of the closest single-line rule before pos, else after pos, else nil.
+func (d *Document) nearestLayout(pos int) *layout {
+	for i := pos - 1; i >= 0; i-- {
+		if n := d.nodes[i]; n.Kind == NodeRule && n.template.singleLine() {
+			return n.template
+		}
+	}
+	for i := pos; i < len(d.nodes); i++ {
+		if n := d.nodes[i]; n.Kind == NodeRule && n.template.singleLine() {
+			return n.template
+		}
+	}
+	return nil
+}
+
+// splitComment splits line at the first '#' outside double quotes. comment includes the '#'.
+func splitComment(line string) (body, comment string) {
+	inQuote := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			inQuote = !inQuote
+		case '#':
+			if !inQuote {
+				return line[:i], line[i:]
+			}
+		}
+	}
+	return line, ""
+}
@@ -0,0 +1,209 @@
+package hba
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDocument_roundTrip(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"\n",
+		"# TYPE  DATABASE  USER  ADDRESS  METHOD\n\nlocal   all   all                 trust   # keep me\n",
+		"host\tall\tall\t127.0.0.1/32\tscram-sha-256",
+		"include_if_exists  extra.conf\nbogus line here\n   \n",
+	} {
+		d := parseDocument(content)
+		if got := string(d.Bytes()); got != content {
+			t.Errorf("round trip: got %q, want %q", got, content)
+		}
+	}
+}
+
+func TestParseDocument_sampleRoundTrip(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "sample-pg_hba.conf"))
+	if err != nil {
+		t.Skip("sample-pg_hba.conf not available")
+	}
+	d := parseDocument(string(data))
+	if got := string(d.Bytes()); got != string(data) {
+		t.Error("sample-pg_hba.conf did not round-trip byte-identically")
+	}
+}
+
+func TestParseDocument_kinds(t *testing.T) {
+	d := parseDocument("# header\n\nlocal all all trust # inline\ninclude pg_hba.d.conf\nhots all all 10.0.0.1/32 md5\n")
+	want := []NodeKind{NodeComment, NodeBlank, NodeRule, NodeDirective, NodeInvalid}
+	nodes := d.Nodes()
+	if len(nodes) != len(want) {
+		t.Fatalf("got %d nodes, want %d", len(nodes), len(want))
+	}
+	for i, k := range want {
+		if nodes[i].Kind != k {
+			t.Errorf("node %d: got %s, want %s", i, nodes[i].Kind, k)
+		}
+	}
+	if nodes[2].Comment() != "# inline" {
+		t.Errorf("inline comment: got %q", nodes[2].Comment())
+	}
+	if nodes[3].Directive != (Directive{Keyword: "include", Path: "pg_hba.d.conf"}) {
+		t.Errorf("directive: got %+v", nodes[3].Directive)
+	}
+}
+
+func TestDocument_insertAlignsToNeighbour(t *testing.T) {
+	d := parseDocument("host    all     all     127.0.0.1/32    trust\n")
+	if err := d.AppendRule(Rule{Type: "host", Database: "db", User: "app", Address: "10.0.0.0/8", Method: "md5"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "host    all     all     127.0.0.1/32    trust\nhost    db      app     10.0.0.0/8      md5\n"
+	if got := string(d.Bytes()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Local rules skip the address column but keep METHOD aligned.
+	if err := d.InsertRule(0, Rule{Type: "local", Database: "all", User: "all", Method: "peer"}); err != nil {
+		t.Fatal(err)
+	}
+	want = "local   all     all                     peer\n" + want
+	if got := string(d.Bytes()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDocument_insertIntoFileWithoutRules(t *testing.T) {
+	d := parseDocument("# no rules yet\n")
+	if err := d.AppendRule(Rule{Type: "local", Database: "all", User: "all", Method: "peer"}); err != nil {
+		t.Fatal(err)
+	}
+	// The first rule has no layout to follow; inserting next to it must not depend on one.
+	if err := d.AppendRule(Rule{Type: "host", Database: "all", User: "app", Address: "10.0.0.0/8", Method: "md5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InsertRule(1, Rule{Type: "local", Database: "all", User: "postgres", Method: "peer"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(d.Rules()); got != 3 {
+		t.Errorf("got %d rules, want 3:\n%s", got, d.Bytes())
+	}
+}
+
+func TestDocument_insertTabs(t *testing.T) {
+	d := parseDocument("local\tall\tall\ttrust\n")
+	if err := d.AppendRule(Rule{Type: "host", Database: "all", User: "app", Address: "10.0.0.1/32", Method: "md5"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "local\tall\tall\ttrust\nhost\tall\tapp\t10.0.0.1/32\tmd5\n"
+	if got := string(d.Bytes()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDocument_replaceKeepsComment(t *testing.T) {
+	d := parseDocument("# c\nhost  all  app  10.0.0.1/32  md5            # office\nlocal all all trust\n")
+	pos, ok := d.RulePos(1)
+	if !ok || pos != 1 {
+		t.Fatalf("RulePos(1) = %d, %v", pos, ok)
+	}
+	r := d.Nodes()[pos].Rule
+	r.Method = "scram-sha-256"
+	if err := d.ReplaceRule(pos, r); err != nil {
+		t.Fatal(err)
+	}
+	want := "# c\nhost  all  app  10.0.0.1/32  scram-sha-256  # office\nlocal all all trust\n"
+	if got := string(d.Bytes()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDocument_removeAndMove(t *testing.T) {
+	d := parseDocument("a # 1\nlocal all a trust\nlocal all b trust\nlocal all c trust\n")
+	if err := d.Move(3, 1); err != nil {
+		t.Fatal(err)
+	}
+	want := "a # 1\nlocal all c trust\nlocal all a trust\nlocal all b trust\n"
+	if got := string(d.Bytes()); got != want {
+		t.Errorf("after move: got %q, want %q", got, want)
+	}
+	d.Remove(0, 3)
+	want = "local all c trust\nlocal all a trust\n"
+	if got := string(d.Bytes()); got != want {
+		t.Errorf("after remove: got %q, want %q", got, want)
+	}
+	rules := d.Rules()
+	if len(rules) != 2 || rules[1].Rule.User != "a" || rules[1].LineNo != 2 || rules[1].Pos != 1 {
+		t.Errorf("rules after edit: %+v", rules)
+	}
+}
+
+func TestParseDocument_continuation(t *testing.T) {
+	content := "local all all trust\n" +
+		"host all all 10.0.0.0/8 ldap \\\n" +
+		"    ldapserver=ldap.example.com \\\n" +
+		"    ldapprefix=\"cn=\" ldapsuffix=\", dc=example, dc=com\"\n" +
+		"local all b trust\n"
+	d := parseDocument(content)
+	if got := string(d.Bytes()); got != content {
+		t.Fatalf("round trip: got %q", got)
+	}
+	rules := d.Rules()
+	if len(rules) != 3 {
+		t.Fatalf("got %d rules, want 3", len(rules))
+	}
+	if rules[1].LineNo != 2 || rules[1].EndLineNo != 4 || rules[2].LineNo != 5 {
+		t.Errorf("spans: %+v", rules)
+	}
+	if r := rules[1].Rule; r.Method != "ldap" || FormatOptions(r.Options) != `ldapserver=ldap.example.com ldapprefix=cn= ldapsuffix=", dc=example, dc=com"` {
+		t.Errorf("logical method: %q %q", r.Method, FormatOptions(r.Options))
+	}
+	if d.LineCount() != 5 {
+		t.Errorf("LineCount = %d, want 5", d.LineCount())
+	}
+
+	// Any line of the span selects the whole rule.
+	pos, ok := d.PosOfLine(3)
+	if !ok || pos != 1 {
+		t.Fatalf("PosOfLine(3) = %d, %v", pos, ok)
+	}
+	d.Remove(pos)
+	if got, want := string(d.Bytes()), "local all all trust\nlocal all b trust\n"; got != want {
+		t.Errorf("after remove: got %q, want %q", got, want)
+	}
+}
+
+func TestDocument_replaceKeepsContinuation(t *testing.T) {
+	d := parseDocument("host all all 10.0.0.0/8 ldap \\\n  ldapserver=a \\\n  ldapport=389\n")
+	r := d.Nodes()[0].Rule
+	r.Address = "10.1.0.0/16"
+	r.Options = []Option{{Name: "ldapserver", Value: "b"}, {Name: "ldapport", Value: "636"}}
+	if err := d.ReplaceRule(0, r); err != nil {
+		t.Fatal(err)
+	}
+	want := "host all all 10.1.0.0/16 ldap \\\n  ldapserver=b \\\n  ldapport=636\n"
+	if got := string(d.Bytes()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReadDocument_writeTo(t *testing.T) {
+	in := "# header\nlocal all all trust\ninclude other.conf\n"
+	cfg, err := ReadConfig(strings.NewReader(in), "<stdin>", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Docs) != 1 || len(cfg.Rules()) != 1 || cfg.Rules()[0].File != "<stdin>" {
+		t.Fatalf("ReadConfig should load a single document without following includes: %+v", cfg.Rules())
+	}
+	if _, err := cfg.AppendRule(Rule{Type: "local", Database: "all", User: "bob", Method: "peer"}); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if _, err := cfg.Root.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if want := in + "local all bob peer\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,83 @@
+package hba
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Filter selects rules by their columns, written as field=value terms separated by spaces or
+// commas (e.g. "user=alice method=md5"). A rule matches when every term does. Fields:
+//
+//	type    the connection type (case-insensitive)
+//	db      a database the rule lists, as remove --user --db matches it
+//	user    a user the rule lists, including names from @file references and regex entries
+//	addr    the address, as remove --addr matches it (10.0.1.7 also matches 10.0.1.7/32)
+//	method  the authentication method
+type Filter struct {
+	terms []filterTerm
+}
+
+type filterTerm struct {
+	field, value string
+}
+
+// filterFields are the fields a Filter accepts, with their aliases.
+var filterFields = map[string]string{
+	"type":     "type",
+	"db":       "db",
+	"database": "db",
+	"user":     "user",
+	"addr":     "addr",
+	"address":  "addr",
+	"method":   "method",
+}
+
+// ParseFilter parses a filter such as "user=alice method=md5".
+func ParseFilter(s string) (Filter, error) {
+	var f Filter
+	for _, term := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		name, value, ok := strings.Cut(term, "=")
+		field := filterFields[strings.ToLower(name)]
+		if !ok || value == "" || field == "" {
+			return Filter{}, fmt.Errorf("invalid filter term %q: use field=value with field one of type, db, user, addr, method", term)
+		}
+		f.terms = append(f.terms, filterTerm{field, value})
+	}
+	if len(f.terms) == 0 {
+		return Filter{}, fmt.Errorf("empty filter: use field=value terms (e.g. user=alice method=md5)")
+	}
+	return f, nil
+}
+
+// Match reports whether x matches every term of f.
+func (f Filter) Match(x RuleWithLine) bool {
+	for _, t := range f.terms {
+		var ok bool
+		switch t.field {
+		case "type":
+			ok = strings.EqualFold(x.Rule.Type, t.value)
+		case "db":
+			q := parseToken(t.value, databaseKeywords)
+			ok = containsToken(x.Rule.Databases(), q) || containsToken(x.DatabaseTokens(), q)
+		case "user":
+			ok = x.MatchesUser(t.value, "")
+		case "addr":
+			ok = x.Rule.MatchesAddress(t.value)
+		case "method":
+			ok = x.Rule.Method == t.value
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the filter as field=value terms.
+func (f Filter) String() string {
+	terms := make([]string, len(f.terms))
+	for i, t := range f.terms {
+		terms[i] = t.field + "=" + t.value
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,53 @@
+package hba
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	doc, err := ReadDocument(strings.NewReader(`local all all peer
+host app,reports alice,+ops 10.0.0.1/32 md5
+hostssl all /^tenant_ 10.0.0.0/8 scram-sha-256
+host all all 0.0.0.0/0 reject
+`), "pg_hba.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := doc.Rules()
+	for _, tc := range []struct {
+		filter string
+		want   []int
+	}{
+		{"user=alice", []int{2}},
+		{"user=+ops,db=reports", []int{2}},
+		{"db=app method=scram-sha-256", nil},
+		{"user=tenant_a", []int{3}},
+		{"type=HOST", []int{2, 4}},
+		{"addr=10.0.0.1", []int{2}},
+		{"method=reject", []int{4}},
+		{"database=all address=0.0.0.0/0", []int{4}},
+	} {
+		f, err := ParseFilter(tc.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", tc.filter, err)
+			continue
+		}
+		var got []int
+		for _, x := range rules {
+			if f.Match(x) {
+				got = append(got, x.Index)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q matched %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+
+	for _, s := range []string{"", "user", "user=", "port=5432"} {
+		if _, err := ParseFilter(s); err == nil {
+			t.Errorf("ParseFilter(%q) should fail", s)
+		}
+	}
+}
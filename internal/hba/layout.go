@@ -0,0 +1,183 @@
+package hba
+
+import "strings"
+
+// Logical columns of a pg_hba.conf record, used to align rendered rules with their neighbours.
+const (
+	colType = iota
+	colDatabase
+	colUser
+	colAddress
+	colNetmask
+	colMethod
+	colOptions
+	numColumns
+)
+
+// column is one field of a rule together with the logical column it belongs to.
+type column struct {
+	col  int
+	text string
+}
+
+// columns returns the fields of r in file order, tagged with their logical column.
+func (r Rule) columns() []column {
+	cols := []column{{colType, r.Type}, {colDatabase, r.Database}, {colUser, r.User}}
+	if hostTypes[strings.ToLower(r.Type)] {
+		cols = append(cols, column{colAddress, r.Address})
+		if r.Netmask != "" {
+			cols = append(cols, column{colNetmask, r.Netmask})
+		}
+	}
+	cols = append(cols, column{colMethod, r.Method})
+	for _, o := range r.Options {
+		cols = append(cols, column{colOptions, o.String()})
+	}
+	return cols
+}
+
+// layout records where each logical column starts in a hand-aligned line.
+// A nil layout renders tab-separated fields, like Rule.Line.
+type layout struct {
+	tabs    bool            // line uses tabs between fields: keep using tabs
+	starts  [numColumns]int // byte offset of each column; -1 when unknown
+	comment int             // byte offset of the inline comment; -1 when none
+	breaks  map[int]string  // field ordinal -> indentation of the continuation line it starts
+}
+
+// layoutOf derives the column layout of a rule parsed as r. raw is the original text (physical
+// lines joined by newlines) and logical the same text with backslash continuations removed.
+func layoutOf(raw, logical string, r Rule) *layout {
+	l := &layout{comment: -1}
+	for i := range l.starts {
+		l.starts[i] = -1
+	}
+	l.breaks = continuationBreaks(raw, logical)
+	if len(l.breaks) > 0 {
+		// Columns of a continued rule are not comparable across lines: only keep its line breaks.
+		l.tabs = strings.Contains(logical, "\t")
+		return l
+	}
+	body, comment := splitComment(logical)
+	if comment != "" {
+		l.comment = len(body)
+	}
+	if strings.Contains(strings.TrimRight(body, " \t"), "\t") {
+		l.tabs = true
+		return l
+	}
+	cols := r.columns()
+	for i, off := range fieldOffsets(body) {
+		if i < len(cols) && l.starts[cols[i].col] < 0 {
+			l.starts[cols[i].col] = off
+		}
+	}
+	return l
+}
+
+// continuationBreaks maps the ordinal of each field that starts a continuation line to that line's
+// indentation.
+func continuationBreaks(raw, logical string) map[int]string {
+	lines := strings.Split(raw, "\n")
+	if len(lines) < 2 {
+		return nil
+	}
+	offsets := fieldOffsets(logical)
+	breaks := make(map[int]string)
+	at := 0
+	for i, ln := range lines[:len(lines)-1] {
+		at += len(strings.TrimSuffix(strings.TrimSuffix(ln, "\r"), "\\"))
+		next := strings.TrimSuffix(lines[i+1], "\r")
+		indent := next[:len(next)-len(strings.TrimLeft(next, " \t"))]
+		for f, off := range offsets {
+			if off >= at && f > 0 {
+				if _, ok := breaks[f]; !ok {
+					breaks[f] = indent
+				}
+				break
+			}
+		}
+	}
+	return breaks
+}
+
+// render joins cols using the layout, followed by comment (if any).
+func (l *layout) render(cols []column, comment string) string {
+	var b strings.Builder
+	for i, c := range cols {
+		if indent, ok := l.lineBreak(i); ok {
+			b.WriteString(" \\\n")
+			b.WriteString(indent)
+		} else if i > 0 {
+			l.pad(&b, l.start(c.col))
+		}
+		b.WriteString(c.text)
+	}
+	if comment != "" {
+		start := -1
+		if l != nil {
+			start = l.comment
+		}
+		l.pad(&b, start)
+		b.WriteString(comment)
+	}
+	return b.String()
+}
+
+// lineBreak reports whether field i starts a continuation line, and its indentation.
+func (l *layout) lineBreak(i int) (string, bool) {
+	if l == nil || i == 0 {
+		return "", false
+	}
+	indent, ok := l.breaks[i]
+	return indent, ok
+}
+
+// singleLine reports whether l is the layout of a rule written on one line; false when there is no
+// layout (a rule added without a neighbour to align to).
+func (l *layout) singleLine() bool {
+	return l != nil && len(l.breaks) == 0
+}
+
+// start returns the offset of col, or -1 if unknown.
+func (l *layout) start(col int) int {
+	if l == nil {
+		return -1
+	}
+	return l.starts[col]
+}
+
+// pad writes the separator before the next field: a tab, or spaces up to offset (at least one).
+func (l *layout) pad(b *strings.Builder, offset int) {
+	if l == nil || l.tabs {
+		b.WriteByte('\t')
+		return
+	}
+	n := 1
+	if offset > b.Len() {
+		n = offset - b.Len()
+	}
+	b.WriteString(strings.Repeat(" ", n))
+}
+
+// fieldOffsets returns the byte offset at which each whitespace-separated field of s starts,
+// treating double-quoted segments as part of a field.
+func fieldOffsets(s string) []int {
+	var offsets []int
+	inField, inQuote := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !inQuote && (c == ' ' || c == '\t') {
+			inField = false
+			continue
+		}
+		if !inField {
+			offsets = append(offsets, i)
+			inField = true
+		}
+		if c == '"' {
+			inQuote = !inQuote
+		}
+	}
+	return offsets
+}
@@ -0,0 +1,102 @@
+package hba
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLockTimeout is how long the package-level edit functions wait for the lock.
+const DefaultLockTimeout = 10 * time.Second
+
+// lockPollInterval is how often a busy lock is retried.
+const lockPollInterval = 100 * time.Millisecond
+
+// Lock is an advisory lock on a pg_hba.conf, held on the sidecar file path.lock. Every hbactl run
+// that reads, modifies and writes the file holds it, so concurrent runs cannot lose each other's
+// changes. The lock file holds the pid of the holder and is left in place when released.
+type Lock struct {
+	Path string // the lock file
+	f    *os.File
+}
+
+// LockedError is returned by AcquireLock when another process holds the lock past the timeout.
+type LockedError struct {
+	Path string // the lock file
+	PID  int    // pid of the holder, 0 if unknown
+}
+
+func (e *LockedError) Error() string {
+	holder := "another process"
+	if e.PID > 0 {
+		holder = fmt.Sprintf("another process (pid %d)", e.PID)
+	}
+	return fmt.Sprintf("%s is locked by %s", e.Path, holder)
+}
+
+// errWouldBlock is returned by tryLock when the lock is held elsewhere.
+var errWouldBlock = errors.New("lock is held")
+
+// AcquireLock takes the lock for path, waiting up to timeout for another holder to release it.
+func AcquireLock(path string, timeout time.Duration) (*Lock, error) {
+	lockPath := path + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err = tryLock(f)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errWouldBlock) {
+			f.Close()
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, &LockedError{Path: lockPath, PID: lockHolder(lockPath)}
+		}
+		time.Sleep(lockPollInterval)
+	}
+	// The pid is informational (see LockedError): failing to record it does not affect the lock.
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &Lock{Path: lockPath, f: f}, nil
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	unlock(l.f)
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// lockHolder returns the pid written in the lock file, or 0.
+func lockHolder(lockPath string) int {
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// withLock runs fn while holding the lock for path.
+func withLock(path string, fn func() error) error {
+	l, err := AcquireLock(path, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	return fn()
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package hba
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pg_hba.conf")
+	l, err := AcquireLock(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = AcquireLock(path, 250*time.Millisecond)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("second AcquireLock: got %v, want LockedError", err)
+	}
+	if locked.PID != os.Getpid() || locked.Path != path+".lock" {
+		t.Errorf("LockedError: %+v", locked)
+	}
+	if time.Since(start) < 250*time.Millisecond {
+		t.Error("AcquireLock should wait for the timeout")
+	}
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = AcquireLock(path, 0)
+	if err != nil {
+		t.Fatalf("after Release: %v", err)
+	}
+	l.Release()
+}
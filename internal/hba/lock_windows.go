@@ -0,0 +1,11 @@
+//go:build windows
+
+package hba
+
+import "os"
+
+// tryLock does not lock on Windows: the lock file only records the pid of the last holder.
+func tryLock(*os.File) error { return nil }
+
+// unlock is a no-op on Windows.
+func unlock(*os.File) error { return nil }
@@ -0,0 +1,248 @@
+package hba
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Connection describes a client connection, to work out which rule PostgreSQL applies to it.
+// Replication connections are not modelled: the replication keyword never matches.
+type Connection struct {
+	User     string
+	Database string
+	Addr     net.IP // client address; nil for a Unix-domain socket
+	SSL      *bool  // whether the connection uses SSL; nil when not known
+	GSSEnc   *bool  // whether the connection uses GSSAPI encryption; nil when not known
+}
+
+// ParseConnection parses a connection written as field=value terms separated by spaces or commas,
+// like a Filter: user and db are required, addr (an IP address) is omitted for a Unix-domain socket,
+// and ssl and gss (on or off) are unknown when omitted. Example: "user=alice db=app addr=10.0.0.5 ssl=on".
+func ParseConnection(s string) (Connection, error) {
+	var c Connection
+	for _, term := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		name, value, ok := strings.Cut(term, "=")
+		if !ok || value == "" {
+			return Connection{}, fmt.Errorf("invalid connection term %q: use field=value with field one of user, db, addr, ssl, gss", term)
+		}
+		switch strings.ToLower(name) {
+		case "user":
+			c.User = value
+		case "db", "database":
+			c.Database = value
+		case "addr", "address":
+			if value == "local" {
+				continue
+			}
+			if c.Addr = net.ParseIP(value); c.Addr == nil {
+				return Connection{}, fmt.Errorf("invalid connection address %q: use an IP address, or omit addr for a Unix-domain socket", value)
+			}
+		case "ssl", "gss":
+			var b bool
+			switch strings.ToLower(value) {
+			case "on", "true", "yes", "1":
+				b = true
+			case "off", "false", "no", "0":
+			default:
+				return Connection{}, fmt.Errorf("invalid connection term %q: use on or off", term)
+			}
+			if strings.ToLower(name) == "ssl" {
+				c.SSL = &b
+			} else {
+				c.GSSEnc = &b
+			}
+		default:
+			return Connection{}, fmt.Errorf("invalid connection term %q: use field=value with field one of user, db, addr, ssl, gss", term)
+		}
+	}
+	if c.User == "" || c.Database == "" {
+		return Connection{}, fmt.Errorf("invalid connection %q: user and db are required (e.g. user=alice db=app addr=10.0.0.5)", s)
+	}
+	return c, nil
+}
+
+// String describes the connection, e.g. "alice@app from 10.0.0.5 (SSL)" or "alice@app on a local socket".
+func (c Connection) String() string {
+	s := c.User + "@" + c.Database
+	if c.Addr == nil {
+		return s + " on a local socket"
+	}
+	s += " from " + c.Addr.String()
+	switch {
+	case c.GSSEnc != nil && *c.GSSEnc:
+		s += " (GSSAPI)"
+	case c.SSL != nil && *c.SSL:
+		s += " (SSL)"
+	}
+	return s
+}
+
+// MatchResult is the outcome of matching a rule against a connection.
+type MatchResult int
+
+const (
+	NoMatch  MatchResult = iota // the rule does not apply to the connection
+	Match                       // the rule applies to the connection
+	MayMatch                    // it depends on what the file does not tell (see MatchConnection)
+)
+
+// MatchConnection reports whether PostgreSQL would apply the rule x to c, checking the connection
+// type, database, user and address as PostgreSQL does; the method plays no part. The result is
+// MayMatch when it depends on what hbactl cannot know from the files: role membership (+role,
+// samerole), samehost and samenet, host names, or an SSL or GSSAPI state that is not known.
+func (x RuleWithLine) MatchConnection(c Connection) MatchResult {
+	result := Match
+	for _, m := range []MatchResult{matchType(x.Rule.Type, c), matchDatabase(x.DatabaseTokens(), c), matchUser(x.UserTokens(), c), matchAddress(x.Rule, c)} {
+		switch m {
+		case NoMatch:
+			return NoMatch
+		case MayMatch:
+			result = MayMatch
+		}
+	}
+	return result
+}
+
+// FirstMatch returns the rule PostgreSQL applies to c: the first rule of rules, in evaluation order,
+// that matches. ok is false when none does (the connection is rejected). certain is false when an
+// earlier rule may match (see MatchConnection), in which case that one could apply instead.
+func FirstMatch(rules []RuleWithLine, c Connection) (x RuleWithLine, certain, ok bool) {
+	candidates := Candidates(rules, c)
+	n := len(candidates)
+	if n == 0 || candidates[n-1].MatchConnection(c) != Match {
+		return RuleWithLine{}, n == 0, false
+	}
+	return candidates[n-1], n == 1, true
+}
+
+// Candidates returns the rules that may apply to c, in evaluation order: those that may match (see
+// MatchConnection), up to and including the first that matches. PostgreSQL applies one of them.
+func Candidates(rules []RuleWithLine, c Connection) []RuleWithLine {
+	var result []RuleWithLine
+	for _, r := range rules {
+		switch r.MatchConnection(c) {
+		case Match:
+			return append(result, r)
+		case MayMatch:
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// known turns a boolean that may be unknown into a MatchResult for "it must be want".
+func known(b *bool, want bool) MatchResult {
+	switch {
+	case b == nil:
+		return MayMatch
+	case *b == want:
+		return Match
+	}
+	return NoMatch
+}
+
+func matchType(typ string, c Connection) MatchResult {
+	if LocalType(typ) != (c.Addr == nil) {
+		return NoMatch
+	}
+	switch typ {
+	case "hostssl":
+		return known(c.SSL, true)
+	case "hostnossl":
+		return known(c.SSL, false)
+	case "hostgssenc":
+		return known(c.GSSEnc, true)
+	case "hostnogssenc":
+		return known(c.GSSEnc, false)
+	}
+	return Match
+}
+
+func matchDatabase(tokens []Token, c Connection) MatchResult {
+	result := NoMatch
+	for _, t := range tokens {
+		switch {
+		case t.Kind == TokenKeyword && t.Value == "all":
+			return Match
+		case t.Kind == TokenKeyword && t.Value == "sameuser":
+			if c.Database == c.User {
+				return Match
+			}
+		case t.Kind == TokenKeyword && (t.Value == "samerole" || t.Value == "samegroup"):
+			result = MayMatch
+		case t.Kind == TokenFile:
+			result = MayMatch // the file could not be read
+		case t.Kind == TokenName || t.Kind == TokenRegex:
+			if t.Matches(Token{Kind: TokenName, Value: c.Database}) {
+				return Match
+			}
+		}
+	}
+	return result
+}
+
+func matchUser(tokens []Token, c Connection) MatchResult {
+	result := NoMatch
+	for _, t := range tokens {
+		switch t.Kind {
+		case TokenKeyword:
+			if t.Value == "all" {
+				return Match
+			}
+		case TokenGroup:
+			// A role is a member of itself; other memberships are not known.
+			if t.Value == c.User {
+				return Match
+			}
+			result = MayMatch
+		case TokenFile:
+			result = MayMatch
+		case TokenName, TokenRegex:
+			if t.Matches(Token{Kind: TokenName, Value: c.User}) {
+				return Match
+			}
+		}
+	}
+	return result
+}
+
+func matchAddress(r Rule, c Connection) MatchResult {
+	if c.Addr == nil {
+		return Match // local rules have no address
+	}
+	switch r.Address {
+	case "all":
+		return Match
+	case "samehost", "samenet":
+		return MayMatch
+	}
+	if r.Netmask != "" {
+		ip, mask := net.ParseIP(r.Address), net.ParseIP(r.Netmask)
+		if ip == nil || mask == nil {
+			return NoMatch
+		}
+		if ip4, mask4 := ip.To4(), mask.To4(); ip4 != nil && mask4 != nil {
+			ip, mask = ip4, mask4
+		}
+		n := net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+		if n.Contains(c.Addr) {
+			return Match
+		}
+		return NoMatch
+	}
+	if _, n, err := net.ParseCIDR(r.Address); err == nil {
+		if n.Contains(c.Addr) {
+			return Match
+		}
+		return NoMatch
+	}
+	if ip := net.ParseIP(r.Address); ip != nil {
+		// An address without mask is rejected by PostgreSQL; treat it as a single host.
+		if ip.Equal(c.Addr) {
+			return Match
+		}
+		return NoMatch
+	}
+	return MayMatch // a host name: resolved by PostgreSQL at connection time
+}
@@ -0,0 +1,68 @@
+package hba
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFirstMatch(t *testing.T) {
+	doc, err := ReadDocument(strings.NewReader(`local   all       postgres                 peer
+local   sameuser  all                      peer
+hostssl app       alice    10.0.0.0/8      cert
+host    app       alice    10.0.0.0 255.0.0.0 md5
+host    app       +ops     0.0.0.0/0       scram-sha-256
+host    /^rep_    all      samenet         md5
+host    all       all      db.example.com  md5
+host    all       all      all             reject
+`), "pg_hba.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := doc.Rules()
+	for _, tc := range []struct {
+		conn    string
+		index   int // 0: no rule matches
+		certain bool
+	}{
+		{"user=postgres db=app", 1, true},
+		{"user=bob db=bob", 2, true},
+		{"user=bob db=app", 0, true},
+		{"user=alice db=app addr=10.1.2.3 ssl=on", 3, true},
+		{"user=alice db=app addr=10.1.2.3 ssl=off", 4, true},
+		{"user=alice db=app addr=10.1.2.3", 4, false}, // hostssl may match first
+		{"user=ops db=app addr=192.168.0.1", 5, true},
+		{"user=bob db=app addr=192.168.0.1", 8, false}, // +ops and the host name may match first
+		{"user=bob db=rep_1 addr=192.168.0.1", 8, false},
+		{"user=bob db=other addr=::1", 8, false},
+	} {
+		c, err := ParseConnection(tc.conn)
+		if err != nil {
+			t.Errorf("ParseConnection(%q): %v", tc.conn, err)
+			continue
+		}
+		x, certain, ok := FirstMatch(rules, c)
+		index := 0
+		if ok {
+			index = x.Index
+		}
+		if index != tc.index || certain != tc.certain {
+			t.Errorf("%s: got rule #%d (certain %v), want #%d (certain %v)", c, index, certain, tc.index, tc.certain)
+		}
+	}
+
+	c, _ := ParseConnection("user=bob db=app addr=192.168.0.1")
+	var got []int
+	for _, x := range Candidates(rules, c) {
+		got = append(got, x.Index)
+	}
+	if want := []int{5, 7, 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Candidates(%s): got %v, want %v", c, got, want)
+	}
+
+	for _, s := range []string{"user=alice", "db=app", "user=alice db=app addr=nope", "user=a db=b ssl=maybe", "user=a db=b port=1"} {
+		if _, err := ParseConnection(s); err == nil {
+			t.Errorf("ParseConnection(%q) should fail", s)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package hba
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NameFile is a file referenced from the database or user column with "@file". It lists names
+// separated by whitespace or commas, one or more per line, with "#" comments. Like Document, lines
+// that are not edited are written back unchanged.
+type NameFile struct {
+	Path string
+
+	lines        []string
+	finalNewline bool
+	changed      bool
+	read         *stamp // content of Path when parsed
+}
+
+// ParseNameFile reads a name list file.
+func ParseNameFile(path string) (*NameFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &NameFile{Path: path, read: newStamp(path, data)}
+	content := string(data)
+	if content == "" {
+		return f, nil
+	}
+	if strings.HasSuffix(content, "\n") {
+		f.finalNewline = true
+		content = strings.TrimSuffix(content, "\n")
+	}
+	f.lines = strings.Split(content, "\n")
+	return f, nil
+}
+
+// Names returns the entries of the file in order, without comments.
+func (f *NameFile) Names() []string {
+	var names []string
+	for _, ln := range f.lines {
+		names = append(names, lineNames(ln)...)
+	}
+	return names
+}
+
+// lineNames splits one line of a name file into names.
+func lineNames(line string) []string {
+	body, _ := splitComment(line)
+	var names []string
+	for _, field := range splitFields(body) {
+		for _, name := range splitList(field) {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// sameName reports whether two entries name the same thing, ignoring quotes that do not change
+// the meaning ("alice" and alice), but not those that do ("all" and all).
+func sameName(a, b string) bool {
+	ta, tb := parseToken(a, userKeywords), parseToken(b, userKeywords)
+	return ta.Kind == tb.Kind && ta.Value == tb.Value
+}
+
+// Contains reports whether name is listed.
+func (f *NameFile) Contains(name string) bool {
+	for _, n := range f.Names() {
+		if sameName(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends name on a line of its own. It returns false if name is already listed.
+func (f *NameFile) Add(name string) bool {
+	if f.Contains(name) {
+		return false
+	}
+	f.lines = append(f.lines, name)
+	f.changed = true
+	return true
+}
+
+// Remove deletes every occurrence of name. A line that only held name is dropped; a line that lists
+// other names as well is rewritten without it (keeping its comment). It returns false if name was not listed.
+func (f *NameFile) Remove(name string) bool {
+	found := false
+	var kept []string
+	for _, ln := range f.lines {
+		names := lineNames(ln)
+		var rest []string
+		for _, n := range names {
+			if !sameName(n, name) {
+				rest = append(rest, n)
+			}
+		}
+		if len(rest) == len(names) {
+			kept = append(kept, ln)
+			continue
+		}
+		found = true
+		_, comment := splitComment(ln)
+		switch {
+		case len(rest) > 0 && comment != "":
+			kept = append(kept, strings.Join(rest, ",")+" "+comment)
+		case len(rest) > 0:
+			kept = append(kept, strings.Join(rest, ","))
+		case comment != "":
+			kept = append(kept, comment)
+		}
+	}
+	if found {
+		f.lines = kept
+		f.changed = true
+	}
+	return found
+}
+
+// Changed reports whether the file has been modified since it was parsed.
+func (f *NameFile) Changed() bool { return f.changed }
+
+// Bytes returns the serialized file.
+func (f *NameFile) Bytes() []byte {
+	out := strings.Join(f.lines, "\n")
+	if f.finalNewline || (f.changed && len(f.lines) > 0) {
+		out += "\n"
+	}
+	return []byte(out)
+}
+
+// WriteFile writes the serialized file to path atomically (see WriteFileAtomic). Like
+// Document.WriteFile, it returns a ConflictError if the file changed on disk since it was parsed.
+func (f *NameFile) WriteFile(path string) error {
+	data := f.Bytes()
+	if path == f.Path {
+		if err := f.read.check(path); err != nil {
+			return err
+		}
+	}
+	if err := WriteFileAtomic(path, data, 0644); err != nil {
+		return err
+	}
+	if path == f.Path {
+		f.read = newStamp(path, data)
+	}
+	return nil
+}
+
+// Reference is an "@file" entry used by one or more rules.
+type Reference struct {
+	Path    string // resolved path of the referenced file
+	Written string // entry as written in the rule (e.g. "@admins.txt")
+	Rules   []int  // 1-based indices of the rules that use it
+}
+
+// resolveRef returns the path of the file referenced by "@name" from a rule in file.
+// Relative names are resolved against the directory of that file.
+func resolveRef(file, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(file), name)
+}
+
+// nameFile returns the parsed name file at path, reading it once per Config.
+func (c *Config) nameFile(path string) (*NameFile, error) {
+	if f, ok := c.nameFiles[path]; ok {
+		return f, nil
+	}
+	f, err := ParseNameFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c.nameFiles[path] = f
+	return f, nil
+}
+
+// expand replaces @file tokens by the tokens listed in the referenced file (recursively), resolved
+// relative to file. keywords are those of the column the tokens come from. Files that cannot be read
+// expand to nothing, as in PostgreSQL; the first such error is returned alongside the result.
+func (c *Config) expand(tokens []Token, file string, keywords map[string]bool, seen []string) ([]Token, error) {
+	var out []Token
+	var firstErr error
+	for _, t := range tokens {
+		if t.Kind != TokenFile {
+			out = append(out, t)
+			continue
+		}
+		path := resolveRef(file, t.Value)
+		for _, s := range seen {
+			if s == path {
+				return out, fmt.Errorf("@%s: reference cycle", t.Value)
+			}
+		}
+		f, err := c.nameFile(path)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("@%s: %w", t.Value, err)
+			}
+			continue
+		}
+		var listed []Token
+		for _, name := range f.Names() {
+			listed = append(listed, parseToken(name, keywords))
+		}
+		inner, err := c.expand(listed, path, keywords, append(seen, path))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		out = append(out, inner...)
+	}
+	return out, firstErr
+}
+
+// References returns the @file entries used by rules, in order of first use.
+func (c *Config) References() []Reference {
+	var refs []Reference
+	byPath := make(map[string]int)
+	for _, x := range c.Rules() {
+		for _, t := range append(x.Rule.Databases(), x.Rule.Users()...) {
+			if t.Kind != TokenFile {
+				continue
+			}
+			path := resolveRef(x.File, t.Value)
+			i, ok := byPath[path]
+			if !ok {
+				i = len(refs)
+				byPath[path] = i
+				refs = append(refs, Reference{Path: path, Written: t.String()})
+			}
+			if n := len(refs[i].Rules); n == 0 || refs[i].Rules[n-1] != x.Index {
+				refs[i].Rules = append(refs[i].Rules, x.Index)
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,72 @@
+package hba
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNameFile_edit(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"admins.txt": "# admins\nalice, carol  # on call\ndave\n"})
+	path := filepath.Join(dir, "admins.txt")
+	f, err := ParseNameFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(f.Names(), ","); got != "alice,carol,dave" {
+		t.Errorf("Names: got %s", got)
+	}
+	if f.Add("dave") {
+		t.Error("Add of listed name should return false")
+	}
+	if !f.Add("erin") || !f.Remove("alice") || !f.Remove("dave") {
+		t.Fatal("edit failed")
+	}
+	if f.Remove("zoe") {
+		t.Error("Remove of unlisted name should return false")
+	}
+	want := "# admins\ncarol # on call\nerin\n"
+	if got := string(f.Bytes()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestConfig_expandsFileReferences(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"pg_hba.conf":    "local @dbs all trust\ninclude sub/extra.conf\n",
+		"dbs":            "app1 app2\n",
+		"sub/extra.conf": "local all @admins.txt,bob peer\nlocal all @missing.txt peer\n",
+		"sub/admins.txt": "alice,@nested.txt\n",
+		"sub/nested.txt": "+ops\n",
+		"admins.txt":     "wrong-dir\n",
+	})
+	cfg, err := LoadConfig(filepath.Join(dir, "pg_hba.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := cfg.Rules()
+	if got := FormatTokens(rules[0].Databases); got != "app1,app2" {
+		t.Errorf("expanded databases: got %s", got)
+	}
+	if got := FormatTokens(rules[1].Users); got != "alice,+ops,bob" {
+		t.Errorf("expanded users (relative to including file): got %s", got)
+	}
+	if got := FormatTokens(rules[2].Users); got != "" {
+		t.Errorf("missing file should expand to nothing: got %s", got)
+	}
+	if rules[1].Rule.User != "@admins.txt,bob" {
+		t.Errorf("written column must be kept: got %s", rules[1].Rule.User)
+	}
+	if !rules[1].MatchesUser("alice", "") || !rules[1].MatchesUser("@admins.txt", "") || rules[1].MatchesUser("wrong-dir", "") {
+		t.Error("MatchesUser should use expanded names")
+	}
+	if !rules[0].MatchesUser("all", "app2") {
+		t.Error("MatchesUser should use expanded databases")
+	}
+	refs := cfg.References()
+	if len(refs) != 3 || refs[1].Path != filepath.Join(dir, "sub", "admins.txt") || refs[1].Rules[0] != 2 {
+		t.Errorf("References: %+v", refs)
+	}
+}
@@ -1,8 +1,7 @@
 package hba
 
 import (
-	"bufio"
-	"os"
+	"fmt"
 	"strings"
 )
 
@@ -13,10 +12,10 @@ var localTypes = map[string]bool{
 
 // hostTypes are connection types that have an address field (5+ fields: type, database, user, address, method [, options]).
 var hostTypes = map[string]bool{
-	"host":        true,
-	"hostssl":     true,
-	"hostnossl":   true,
-	"hostgssenc":  true,
+	"host":         true,
+	"hostssl":      true,
+	"hostnossl":    true,
+	"hostgssenc":   true,
 	"hostnogssenc": true,
 }
 
@@ -26,11 +25,47 @@ func LocalType(typ string) bool { return localTypes[typ] }
 // HostType returns true if typ is a host connection type (has address).
 func HostType(typ string) bool { return hostTypes[typ] }
 
-// RuleWithLine holds a rule, its 1-based file line number, and its 1-based rule index (order in file).
+// RuleWithLine holds a rule, its 1-based file line number(s), and its 1-based rule index (order in file).
 type RuleWithLine struct {
-	Rule   Rule
-	LineNo int // line number in file
-	Index  int // 1-based rule number in file (for remove --index)
+	Rule      Rule
+	File      string // file the rule lives in (differs from the top-level file for included rules)
+	LineNo    int    // line number in file
+	EndLineNo int    // last physical line of the rule (greater than LineNo for backslash-continued rules)
+	Index     int    // 1-based rule number in file (for remove --index)
+	Pos       int    // position of the rule's node in its Document
+
+	// Databases and Users are the rule's columns with @file references expanded (set by Config.Rules;
+	// nil when the rule was not read through a Config).
+	Databases []Token
+	Users     []Token
+}
+
+// MatchesUser is like Rule.MatchesUser, but also matches names listed in @file references.
+func (x RuleWithLine) MatchesUser(user, db string) bool {
+	if x.Rule.MatchesUser(user, db) {
+		return true
+	}
+	if x.Users == nil || user == "" || !containsToken(x.Users, parseToken(user, userKeywords)) {
+		return false
+	}
+	q := parseToken(db, databaseKeywords)
+	return db == "" || containsToken(x.Rule.Databases(), q) || containsToken(x.DatabaseTokens(), q)
+}
+
+// UserTokens returns the expanded user list if available, else the rule's user column.
+func (x RuleWithLine) UserTokens() []Token {
+	if x.Users != nil {
+		return x.Users
+	}
+	return x.Rule.Users()
+}
+
+// DatabaseTokens returns the expanded database list if available, else the rule's database column.
+func (x RuleWithLine) DatabaseTokens() []Token {
+	if x.Databases != nil {
+		return x.Databases
+	}
+	return x.Rule.Databases()
 }
 
 // ParseFile reads path and returns parsed rules. Comment and empty lines are skipped.
@@ -48,85 +83,90 @@ func ParseFile(path string) ([]Rule, error) {
 
 // ParseFileWithLineNumbers reads path and returns parsed rules with their 1-based file line numbers.
 func ParseFileWithLineNumbers(path string) ([]RuleWithLine, error) {
-	f, err := os.Open(path)
+	doc, err := ParseDocument(path)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-
-	var result []RuleWithLine
-	scanner := bufio.NewScanner(f)
-	lineNo := 0
-	for scanner.Scan() {
-		lineNo++
-		line := strings.TrimSpace(scanner.Text())
-		if i := strings.Index(line, "#"); i >= 0 {
-			line = strings.TrimSpace(line[:i])
-		}
-		if line == "" {
-			continue
-		}
-		rule, ok := parseLine(line)
-		if !ok {
-			continue
-		}
-		result = append(result, RuleWithLine{Rule: rule, LineNo: lineNo, Index: len(result) + 1})
-	}
-	return result, scanner.Err()
+	return doc.Rules(), nil
 }
 
 // parseLine parses one line into a Rule. Returns ok=false if the line is not a valid rule.
 func parseLine(line string) (Rule, bool) {
+	r, err := parseRule(line)
+	return r, err == nil
+}
+
+// syntaxError tells why a line is not a valid rule. field is the 0-based index of the offending
+// field, or the number of fields when one is missing at the end of the line.
+type syntaxError struct {
+	field int
+	msg   string
+}
+
+// parseRule parses one line into a Rule, or reports why it cannot.
+func parseRule(line string) (Rule, *syntaxError) {
 	fields := splitFields(line)
-	if len(fields) < 4 {
-		return Rule{}, false
+	if len(fields) == 0 {
+		return Rule{}, &syntaxError{0, "empty rule"}
+	}
+	if strings.Count(line, `"`)%2 != 0 {
+		return Rule{}, &syntaxError{len(fields) - 1, "unterminated quoted string"}
 	}
 	typ := strings.ToLower(fields[0])
+	if directiveKeywords[typ] {
+		if len(fields) < 2 {
+			return Rule{}, &syntaxError{1, fmt.Sprintf("missing file name after %s", typ)}
+		}
+		return Rule{}, &syntaxError{2, fmt.Sprintf("extra fields after %s file name", typ)}
+	}
+	if !localTypes[typ] && !hostTypes[typ] {
+		return Rule{}, &syntaxError{0, fmt.Sprintf("invalid connection type %q", fields[0])}
+	}
+	if len(fields) < 2 {
+		return Rule{}, &syntaxError{1, "end-of-line before database specification"}
+	}
+	if len(fields) < 3 {
+		return Rule{}, &syntaxError{2, "end-of-line before role specification"}
+	}
 	if localTypes[typ] {
 		// local database user method [options]
 		if len(fields) < 4 {
-			return Rule{}, false
-		}
-		method := fields[3]
-		if len(fields) > 4 {
-			method = strings.Join(fields[3:], " ")
+			return Rule{}, &syntaxError{3, "end-of-line before authentication method"}
 		}
 		return Rule{
 			Type:     typ,
 			Database: fields[1],
 			User:     fields[2],
 			Address:  "-",
-			Method:   method,
-		}, true
-	}
-	if hostTypes[typ] {
-		// host database user address method [options]
-		// or legacy: host database user IP-ADDRESS IP-MASK method [options] (6+ fields)
-		if len(fields) < 5 {
-			return Rule{}, false
-		}
-		addr := fields[3]
-		netmask := ""
-		methodStart := 4
-		if len(fields) >= 6 && looksLikeNetmask(fields[4]) {
-			// Legacy format: IP + netmask
-			netmask = fields[4]
-			methodStart = 5
-		}
-		method := fields[methodStart]
-		if len(fields) > methodStart+1 {
-			method = strings.Join(fields[methodStart:], " ")
-		}
-		return Rule{
-			Type:     typ,
-			Database: fields[1],
-			User:     fields[2],
-			Address:  addr,
-			Netmask:  netmask,
-			Method:   method,
-		}, true
+			Method:   fields[3],
+			Options:  parseOptions(fields[4:]),
+		}, nil
 	}
-	return Rule{}, false
+	// host database user address method [options]
+	// or legacy: host database user IP-ADDRESS IP-MASK method [options] (6+ fields)
+	if len(fields) < 4 {
+		return Rule{}, &syntaxError{3, "end-of-line before IP address specification"}
+	}
+	if len(fields) < 5 {
+		return Rule{}, &syntaxError{4, "end-of-line before authentication method"}
+	}
+	addr := fields[3]
+	netmask := ""
+	methodStart := 4
+	if len(fields) >= 6 && looksLikeNetmask(fields[4]) {
+		// Legacy format: IP + netmask
+		netmask = fields[4]
+		methodStart = 5
+	}
+	return Rule{
+		Type:     typ,
+		Database: fields[1],
+		User:     fields[2],
+		Address:  addr,
+		Netmask:  netmask,
+		Method:   fields[methodStart],
+		Options:  parseOptions(fields[methodStart+1:]),
+	}, nil
 }
 
 // looksLikeNetmask returns true if s looks like a legacy netmask (dotted decimal or IPv6 hex).
@@ -146,6 +186,7 @@ func looksLikeNetmask(s string) bool {
 }
 
 // splitFields splits by whitespace, respecting double-quoted segments (one field may contain spaces).
+// Quotes are kept in the field text so that quoted names stay distinct from keywords.
 func splitFields(s string) []string {
 	var fields []string
 	var buf strings.Builder
@@ -155,6 +196,7 @@ func splitFields(s string) []string {
 		switch {
 		case c == '"':
 			inQuote = !inQuote
+			buf.WriteByte(c)
 		case inQuote:
 			buf.WriteByte(c)
 		case c == ' ' || c == '\t':
@@ -3,6 +3,7 @@ package hba
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -108,3 +109,58 @@ func TestParseFile_hostssl(t *testing.T) {
 		t.Errorf("got %+v", rules[0])
 	}
 }
+
+func TestParseLine_options(t *testing.T) {
+	r, ok := parseLine(`host all all 10.0.0.0/8 ldap ldapserver=ldap.example.com ldapsuffix=", dc=example" ldaptls=1,ldapport=636`)
+	if !ok {
+		t.Fatal("parseLine failed")
+	}
+	want := []Option{
+		{Name: "ldapserver", Value: "ldap.example.com"},
+		{Name: "ldapsuffix", Value: ", dc=example"},
+		{Name: "ldaptls", Value: "1"},
+		{Name: "ldapport", Value: "636"},
+	}
+	if r.Method != "ldap" || len(r.Options) != len(want) {
+		t.Fatalf("got method %q options %+v", r.Method, r.Options)
+	}
+	for i := range want {
+		if r.Options[i] != want[i] {
+			t.Errorf("option %d: got %+v, want %+v", i, r.Options[i], want[i])
+		}
+	}
+	if v, ok := r.Option("ldapsuffix"); !ok || v != ", dc=example" {
+		t.Errorf("Option(ldapsuffix) = %q, %v", v, ok)
+	}
+	r = Rule{Type: "local", Database: "all", User: "all", Method: "peer", Options: []Option{{Name: "map", Value: "my map"}}}
+	if got := r.Line(); got != "local\tall\tall\tpeer\tmap=\"my map\"" {
+		t.Errorf("Line: got %q", got)
+	}
+	if _, err := ParseOption("novalue"); err == nil {
+		t.Error("ParseOption should reject an option without '='")
+	}
+}
+
+func TestParseLine_emptyOptionValue(t *testing.T) {
+	line := `host all all 10.0.0.0/8 ldap ldapserver=x ldapprefix="" ldapsuffix=",dc=x" map`
+	r, ok := parseLine(line)
+	if !ok {
+		t.Fatal("parseLine failed")
+	}
+	want := []Option{
+		{Name: "ldapserver", Value: "x"},
+		{Name: "ldapprefix", Value: ""},
+		{Name: "ldapsuffix", Value: ",dc=x"},
+		{Name: "map", NoValue: true},
+	}
+	if !reflect.DeepEqual(r.Options, want) {
+		t.Fatalf("options: got %+v, want %+v", r.Options, want)
+	}
+	if got := FormatOptions(r.Options); got != `ldapserver=x ldapprefix="" ldapsuffix=",dc=x" map` {
+		t.Errorf("FormatOptions: got %q", got)
+	}
+	again, ok := parseLine(r.Line())
+	if !ok || !reflect.DeepEqual(again.Options, r.Options) {
+		t.Errorf("round trip: got %+v from %q", again.Options, r.Line())
+	}
+}
@@ -1,33 +1,210 @@
 package hba
 
-import "strings"
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
 
 // Rule represents one entry in pg_hba.conf.
 // Order matters: PostgreSQL uses the first matching rule.
 type Rule struct {
 	Type     string // local, host, hostssl, hostnossl, hostgssenc, hostnogssenc
-	Database string // database name or "all", "sameuser", "samerole", "replication"
-	User     string // user name or "all"
+	Database string // comma-separated: database name, "all", "sameuser", "samerole", "replication", @file
+	User     string // comma-separated: user name, "all", +group, @file
 	Address  string // IP/CIDR or "samehost", "samenet"; "-" for local
 	Netmask  string // optional: legacy IP netmask (e.g. 255.255.255.0); empty when using CIDR
-	Method   string // trust, reject, scram-sha-256, md5, etc.; may include auth options
+	Method   string // trust, reject, scram-sha-256, md5, etc.
+	Options  []Option
 }
 
-// MatchesUser returns true if the rule matches the given user and optional database.
-// If db is empty, only user is matched. User and db are compared case-sensitively.
+// Option is one authentication option of a rule, written name=value (e.g. map=my_map).
+type Option struct {
+	Name    string
+	Value   string
+	NoValue bool // written without "=", which PostgreSQL rejects; Value is then empty
+}
+
+// String returns the option as written in pg_hba.conf, quoting the value if it contains whitespace,
+// commas, '#' or quotes, or is empty (name=""). An option without "=" is written as its bare name.
+func (o Option) String() string {
+	if o.NoValue {
+		return o.Name
+	}
+	return o.Name + "=" + quoteIfNeeded(o.Value, false)
+}
+
+// ParseOption parses a name=value option. The value may be double-quoted.
+func ParseOption(s string) (Option, error) {
+	name, value, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return Option{}, fmt.Errorf("invalid option %q: expected name=value", s)
+	}
+	value, _ = unquote(value)
+	return Option{Name: name, Value: value}, nil
+}
+
+// parseOptions parses the fields that follow the method. Like PostgreSQL, a field may hold several
+// comma-separated options. A field without "=" (which PostgreSQL rejects) is kept as an option with
+// no value so that rewriting the rule does not drop it.
+func parseOptions(fields []string) []Option {
+	var opts []Option
+	for _, f := range fields {
+		for _, item := range splitList(f) {
+			if item == "" {
+				continue
+			}
+			o, err := ParseOption(item)
+			if err != nil {
+				o = Option{Name: item, NoValue: true}
+			}
+			opts = append(opts, o)
+		}
+	}
+	return opts
+}
+
+// FormatOptions joins options as they would be written after the method (space-separated).
+func FormatOptions(opts []Option) string {
+	parts := make([]string, len(opts))
+	for i, o := range opts {
+		parts[i] = o.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// NewRule builds a rule from values as 'hbactl add' takes them: the type is lower-cased, an empty
+// database or user means all, names are quoted where PostgreSQL needs it and a local rule gets no
+// address. It fails for an unknown type or a host rule without address; see Validate for the rest.
+func NewRule(typ, db, user, addr, netmask, method string, options []Option) (Rule, error) {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	if db = strings.TrimSpace(db); db == "" {
+		db = "all"
+	}
+	if user = strings.TrimSpace(user); user == "" {
+		user = "all"
+	}
+	// Re-serialize so names with spaces, commas or keyword spellings are quoted as PostgreSQL expects.
+	db = FormatTokens(ParseDatabases(db))
+	user = FormatTokens(ParseUsers(user))
+	addr = strings.TrimSpace(addr)
+	netmask = strings.TrimSpace(netmask)
+
+	if !LocalType(typ) && !HostType(typ) {
+		return Rule{}, fmt.Errorf("invalid type %q; use one of: local, host, hostssl, hostnossl, hostgssenc, hostnogssenc", typ)
+	}
+	if LocalType(typ) {
+		addr, netmask = "-", ""
+	} else if addr == "" {
+		return Rule{}, fmt.Errorf("addr is required for type %s (e.g. 127.0.0.1/32, samehost)", typ)
+	}
+	return Rule{Type: typ, Database: db, User: user, Address: addr, Netmask: netmask, Method: strings.TrimSpace(method), Options: options}, nil
+}
+
+// Update is a change to the columns of a rule, as 'hbactl update' takes it. Empty fields are left
+// unchanged.
+type Update struct {
+	DB           string
+	User         string
+	Addr         string
+	Method       string
+	SetOptions   []Option // replace the option of the same name, or append it
+	UnsetOptions []string // names of options to remove
+}
+
+// Apply returns r with u applied. Database and user are quoted like NewRule does. A new address in
+// CIDR form, or a keyword or host name, drops the rule's netmask; a bare IP address keeps it. The
+// result is not validated; see Validate.
+func (u Update) Apply(r Rule) (Rule, error) {
+	if db := strings.TrimSpace(u.DB); db != "" {
+		r.Database = FormatTokens(ParseDatabases(db))
+	}
+	if user := strings.TrimSpace(u.User); user != "" {
+		r.User = FormatTokens(ParseUsers(user))
+	}
+	if addr := strings.TrimSpace(u.Addr); addr != "" {
+		if LocalType(r.Type) {
+			return r, fmt.Errorf("a %s rule has no address", r.Type)
+		}
+		r.Address = addr
+		if net.ParseIP(addr) == nil {
+			r.Netmask = ""
+		}
+	}
+	if method := strings.TrimSpace(u.Method); method != "" {
+		r.Method = method
+	}
+	// Options are copied so that r, which shares them with the rule it came from, is not modified.
+	options := append([]Option(nil), r.Options...)
+	for _, o := range u.SetOptions {
+		i := slices.IndexFunc(options, func(x Option) bool { return x.Name == o.Name })
+		if i < 0 {
+			options = append(options, o)
+		} else {
+			options[i] = o
+		}
+	}
+	for _, name := range u.UnsetOptions {
+		options = slices.DeleteFunc(options, func(x Option) bool { return x.Name == name })
+	}
+	r.Options = options
+	return r, nil
+}
+
+// Option returns the value of the named option and whether the rule sets it.
+func (r Rule) Option(name string) (string, bool) {
+	for _, o := range r.Options {
+		if o.Name == name {
+			return o.Value, true
+		}
+	}
+	return "", false
+}
+
+// Databases returns the database column as a list of tokens (e.g. "app1,app2" or "all").
+func (r Rule) Databases() []Token { return parseTokens(r.Database, databaseKeywords) }
+
+// Users returns the user column as a list of tokens (e.g. "alice,+ops" or "all").
+func (r Rule) Users() []Token { return parseTokens(r.User, userKeywords) }
+
+// MatchesUser returns true if the rule lists the given user and, if db is not empty, the given database.
+// user and db are entries as they would be written in the file: "alice" matches "bob,alice"; "+ops"
+// matches a "+ops" entry; "tenant_a" matches a "/^tenant_" regex entry. Keywords only match themselves
+// ("all" matches all, not every user) and a quoted name never matches a keyword ("\"all\"" does not
+// match all). Comparison is case-sensitive.
 func (r Rule) MatchesUser(user, db string) bool {
 	if user == "" {
 		return false
 	}
-	if r.User != user {
+	if !containsToken(r.Users(), parseToken(user, userKeywords)) {
 		return false
 	}
-	if db != "" && r.Database != db {
+	if db != "" && !containsToken(r.Databases(), parseToken(db, databaseKeywords)) {
 		return false
 	}
 	return true
 }
 
+// WithoutUser returns a copy of r with every entry written as user removed from the user column.
+// Regex entries are kept even if their pattern matches user. ok is false when no entry would be left
+// (the rule should be removed instead).
+func (r Rule) WithoutUser(user string) (out Rule, ok bool) {
+	q := parseToken(user, userKeywords)
+	var kept []Token
+	for _, t := range r.Users() {
+		if t.Kind != q.Kind || t.Value != q.Value {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == 0 {
+		return r, false
+	}
+	r.User = FormatTokens(kept)
+	return r, true
+}
+
 // MatchesAddress returns true if the rule's address matches the given addr.
 // Matches exact Address, or addr as CIDR (e.g. 10.0.1.7 matches 10.0.1.7 or 10.0.1.7/32).
 func (r Rule) MatchesAddress(addr string) bool {
@@ -0,0 +1,38 @@
+package hba
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpdate_apply(t *testing.T) {
+	orig := Rule{Type: "host", Database: "app", User: "alice", Address: "10.0.0.1", Netmask: "255.255.255.255", Method: "md5",
+		Options: []Option{{Name: "map", Value: "m"}, {Name: "clientcert", Value: "verify-ca"}}}
+
+	r, err := Update{Method: "scram-sha-256", SetOptions: []Option{{Name: "clientcert", Value: "verify-full"}, {Name: "x", Value: "y"}}, UnsetOptions: []string{"map"}}.Apply(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Option{{Name: "clientcert", Value: "verify-full"}, {Name: "x", Value: "y"}}; r.Method != "scram-sha-256" || !reflect.DeepEqual(r.Options, want) {
+		t.Errorf("method/options: got %q %v", r.Method, r.Options)
+	}
+	if orig.Options[0].Name != "map" || orig.Options[1].Value != "verify-ca" {
+		t.Errorf("Apply modified the original options: %v", orig.Options)
+	}
+
+	if r, _ := (Update{Addr: "10.0.0.2"}).Apply(orig); r.Address != "10.0.0.2" || r.Netmask != "255.255.255.255" {
+		t.Errorf("bare IP: got %s %s, want the netmask kept", r.Address, r.Netmask)
+	}
+	if r, _ := (Update{Addr: "10.0.0.0/8"}).Apply(orig); r.Address != "10.0.0.0/8" || r.Netmask != "" {
+		t.Errorf("CIDR: got %s %s, want the netmask dropped", r.Address, r.Netmask)
+	}
+	if r, _ := (Update{DB: "my db", User: "all,+ops"}).Apply(orig); r.Database != `"my db"` || r.User != "all,+ops" {
+		t.Errorf("db/user: got %s %s", r.Database, r.User)
+	}
+	if r, _ := (Update{}).Apply(orig); !reflect.DeepEqual(r, orig) {
+		t.Errorf("empty update: got %+v, want the rule unchanged", r)
+	}
+	if _, err := (Update{Addr: "10.0.0.0/8"}).Apply(Rule{Type: "local", Database: "all", User: "all", Address: "-", Method: "peer"}); err == nil {
+		t.Error("setting the address of a local rule should fail")
+	}
+}
@@ -6,15 +6,18 @@ import "sort"
 var SortColumns = []string{"type", "database", "user", "address", "method"}
 
 // SortRules sorts rules by the given column (type, database, user, address, method).
-// Order in pg_hba.conf matters for matching; this is for display only.
+// Order in pg_hba.conf matters for matching; this is for display only. Database and user columns sort
+// keywords first, then names, +groups, @files and regex entries.
 func SortRules(rules []Rule, by string) {
 	switch by {
 	case "type":
 		sort.Slice(rules, func(i, j int) bool { return rules[i].Type < rules[j].Type })
 	case "database":
-		sort.Slice(rules, func(i, j int) bool { return rules[i].Database < rules[j].Database })
+		sort.Slice(rules, func(i, j int) bool {
+			return sortKey(rules[i].Databases()) < sortKey(rules[j].Databases())
+		})
 	case "user":
-		sort.Slice(rules, func(i, j int) bool { return rules[i].User < rules[j].User })
+		sort.Slice(rules, func(i, j int) bool { return sortKey(rules[i].Users()) < sortKey(rules[j].Users()) })
 	case "address":
 		sort.Slice(rules, func(i, j int) bool { return rules[i].Address < rules[j].Address })
 	case "method":
@@ -23,14 +26,19 @@ func SortRules(rules []Rule, by string) {
 }
 
 // SortRulesWithLine sorts RuleWithLine slice by the Rule field (same columns as SortRules).
+// Database and user columns sort by their @file-expanded lists when available.
 func SortRulesWithLine(rwl []RuleWithLine, by string) {
 	switch by {
 	case "type":
 		sort.Slice(rwl, func(i, j int) bool { return rwl[i].Rule.Type < rwl[j].Rule.Type })
 	case "database":
-		sort.Slice(rwl, func(i, j int) bool { return rwl[i].Rule.Database < rwl[j].Rule.Database })
+		sort.Slice(rwl, func(i, j int) bool {
+			return sortKey(rwl[i].DatabaseTokens()) < sortKey(rwl[j].DatabaseTokens())
+		})
 	case "user":
-		sort.Slice(rwl, func(i, j int) bool { return rwl[i].Rule.User < rwl[j].Rule.User })
+		sort.Slice(rwl, func(i, j int) bool {
+			return sortKey(rwl[i].UserTokens()) < sortKey(rwl[j].UserTokens())
+		})
 	case "address":
 		sort.Slice(rwl, func(i, j int) bool { return rwl[i].Rule.Address < rwl[j].Rule.Address })
 	case "method":
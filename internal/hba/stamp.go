@@ -0,0 +1,67 @@
+package hba
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"time"
+)
+
+// stamp identifies the content of a file as it was read, so that a later write can tell whether
+// someone else changed the file in between.
+type stamp struct {
+	size    int64
+	modTime time.Time
+	sum     [sha256.Size]byte
+}
+
+// newStamp records data, just read from path.
+func newStamp(path string, data []byte) *stamp {
+	s := &stamp{size: int64(len(data)), sum: sha256.Sum256(data)}
+	if fi, err := os.Stat(path); err == nil {
+		s.modTime = fi.ModTime()
+	}
+	return s
+}
+
+// ConflictError is returned when a file changed on disk since it was read: writing it would
+// overwrite (or, with line-based edits, misapply to) someone else's changes.
+type ConflictError struct {
+	Path    string
+	ReadMod time.Time // modification time when the file was read
+	ModTime time.Time // modification time of the file on disk now (zero if it was removed)
+}
+
+func (e *ConflictError) Error() string {
+	if e.ModTime.IsZero() {
+		return fmt.Sprintf("%s was removed since it was read", e.Path)
+	}
+	return fmt.Sprintf("%s was modified since it was read (modification time %s, now %s)", e.Path,
+		e.ReadMod.Format(time.RFC3339), e.ModTime.Format(time.RFC3339))
+}
+
+// check returns a ConflictError if path no longer holds the content s was made from. A nil stamp
+// (content not read from path) never conflicts. A file that was only touched (same content, new
+// modification time) does not conflict.
+func (s *stamp) check(path string) error {
+	if s == nil {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return &ConflictError{Path: path, ReadMod: s.modTime}
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Size() == s.size {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if sha256.Sum256(data) == s.sum {
+			return nil
+		}
+	}
+	return &ConflictError{Path: path, ReadMod: s.modTime, ModTime: fi.ModTime()}
+}
@@ -0,0 +1,62 @@
+package hba
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDocument_WriteFile_conflict(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pg_hba.conf")
+	if err := os.WriteFile(path, []byte("local all all peer\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.AppendRule(Rule{Type: "local", Database: "all", User: "app", Method: "peer"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Touching the file without changing it is not a conflict.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.CheckUnchanged(); err != nil {
+		t.Fatalf("touched file: %v", err)
+	}
+
+	// Someone else edits the file: writing must be refused and the file left alone.
+	other := "local all all trust\n"
+	if err := os.WriteFile(path, []byte(other), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var conflict *ConflictError
+	if err := cfg.CheckUnchanged(); !errors.As(err, &conflict) || conflict.Path != path {
+		t.Fatalf("CheckUnchanged: got %v, want ConflictError", err)
+	}
+	if err := cfg.Root.WriteFile(path); !errors.As(err, &conflict) {
+		t.Fatalf("WriteFile: got %v, want ConflictError", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != other {
+		t.Errorf("file was overwritten: %q", data)
+	}
+
+	// After re-reading, the edit goes through, and a second write of the same document is fine.
+	cfg, err = LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AppendRule(Rule{Type: "local", Database: "all", User: "app", Method: "peer"})
+	if err := cfg.Root.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Root.WriteFile(path); err != nil {
+		t.Errorf("second write: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package hba
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// TokenKind identifies the kind of an entry in a database or user list.
+type TokenKind int
+
+const (
+	TokenName    TokenKind = iota // database or role name
+	TokenKeyword                  // all, sameuser, samerole, samegroup, replication
+	TokenGroup                    // +role: members of role
+	TokenFile                     // @file: names read from a file
+	TokenRegex                    // /regex: names matching a regular expression (PostgreSQL 16+)
+)
+
+// String returns a short name for the kind (e.g. "group").
+func (k TokenKind) String() string {
+	switch k {
+	case TokenName:
+		return "name"
+	case TokenKeyword:
+		return "keyword"
+	case TokenGroup:
+		return "group"
+	case TokenFile:
+		return "file"
+	case TokenRegex:
+		return "regex"
+	}
+	return "unknown"
+}
+
+// Token is one entry of the comma-separated database or user column.
+// A quoted entry is never a keyword, group or file: "all" is a role named all, while all is the keyword.
+type Token struct {
+	Kind   TokenKind
+	Value  string // name or keyword; role without "+"; file without "@"; pattern without "/"; unquoted
+	Quoted bool   // written (at least partly) in double quotes
+}
+
+// databaseKeywords are the keywords allowed in the database column.
+var databaseKeywords = map[string]bool{
+	"all":         true,
+	"sameuser":    true,
+	"samerole":    true,
+	"samegroup":   true,
+	"replication": true,
+}
+
+// userKeywords are the keywords allowed in the user column.
+var userKeywords = map[string]bool{
+	"all": true,
+}
+
+// String returns the token as written in pg_hba.conf (e.g. "+admins", "@users.txt", "\"all\"").
+// Names are quoted when they were quoted or would otherwise change meaning: when they contain
+// whitespace, commas, '#' or quotes, collide with a keyword or start with '+', '@' or '/'.
+func (t Token) String() string {
+	switch t.Kind {
+	case TokenKeyword:
+		return t.Value
+	case TokenGroup:
+		return "+" + quoteIfNeeded(t.Value, t.Quoted)
+	case TokenFile:
+		return "@" + quoteIfNeeded(t.Value, t.Quoted)
+	case TokenRegex:
+		return quoteIfNeeded("/"+t.Value, t.Quoted)
+	}
+	force := t.Quoted || databaseKeywords[t.Value] || userKeywords[t.Value]
+	if t.Value != "" && strings.ContainsRune("+@/", rune(t.Value[0])) {
+		force = true
+	}
+	return quoteIfNeeded(t.Value, force)
+}
+
+// quoteIfNeeded double-quotes s if force is set or s contains characters that end or split a field.
+// Embedded quotes are doubled, as PostgreSQL expects.
+func quoteIfNeeded(s string, force bool) string {
+	if !force && s != "" && !strings.ContainsAny(s, " \t,#\"") {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// Matches reports whether the token stands for q, an entry parsed from the same column: the same
+// kind and value (quoting aside), or a regex token whose pattern matches the name q.
+// A quoted name never matches a keyword: "all" does not match all. Membership of groups and
+// files is not resolved.
+func (t Token) Matches(q Token) bool {
+	if t.Kind == q.Kind && t.Value == q.Value {
+		return true
+	}
+	if t.Kind == TokenRegex && q.Kind == TokenName {
+		re, err := t.Regexp()
+		return err == nil && re.MatchString(q.Value)
+	}
+	return false
+}
+
+// regexCache holds compiled patterns of regex tokens, keyed by pattern.
+var regexCache sync.Map
+
+// Regexp compiles the pattern of a regex token. Like PostgreSQL, the pattern is not implicitly
+// anchored (use ^ and $) and matching is case-sensitive. PostgreSQL's advanced regular expressions
+// and Go's RE2 agree on the common subset (anchors, classes, alternation, repetition); constructs
+// RE2 does not support, such as back-references and lookahead, return an error.
+func (t Token) Regexp() (*regexp.Regexp, error) {
+	if t.Kind != TokenRegex {
+		return nil, fmt.Errorf("%q is not a regular expression", t.String())
+	}
+	if re, ok := regexCache.Load(t.Value); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(t.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %w", t.Value, err)
+	}
+	regexCache.Store(t.Value, re)
+	return re, nil
+}
+
+// ParseDatabases parses a database column (e.g. `app1,"my db",@dbs.txt`) into tokens.
+func ParseDatabases(s string) []Token { return parseTokens(s, databaseKeywords) }
+
+// ParseUsers parses a user column (e.g. `alice,+ops,"all"`) into tokens.
+func ParseUsers(s string) []Token { return parseTokens(s, userKeywords) }
+
+// parseTokens splits a database or user column into tokens at commas outside double quotes.
+// keywords are the bare words with a special meaning in that column.
+func parseTokens(s string, keywords map[string]bool) []Token {
+	if s == "" {
+		return nil
+	}
+	var tokens []Token
+	for _, item := range splitList(s) {
+		tokens = append(tokens, parseToken(item, keywords))
+	}
+	return tokens
+}
+
+// parseToken parses one list entry as written. Keywords, +group and @file are only recognised
+// unquoted; a regex may be quoted (needed when the pattern contains a comma).
+func parseToken(s string, keywords map[string]bool) Token {
+	v, quoted := unquote(s)
+	if !quoted {
+		switch {
+		case keywords[v]:
+			return Token{Kind: TokenKeyword, Value: v}
+		case len(v) > 1 && v[0] == '+':
+			return Token{Kind: TokenGroup, Value: v[1:]}
+		case len(v) > 1 && v[0] == '@':
+			return Token{Kind: TokenFile, Value: v[1:]}
+		}
+	} else if len(s) > 1 && (s[0] == '+' || s[0] == '@') {
+		// +"name" / @"file": the prefix is outside the quotes, so it keeps its meaning.
+		inner, _ := unquote(s[1:])
+		if s[0] == '+' {
+			return Token{Kind: TokenGroup, Value: inner, Quoted: true}
+		}
+		return Token{Kind: TokenFile, Value: inner, Quoted: true}
+	}
+	if len(v) > 1 && v[0] == '/' {
+		return Token{Kind: TokenRegex, Value: v[1:], Quoted: quoted}
+	}
+	return Token{Kind: TokenName, Value: v, Quoted: quoted}
+}
+
+// unquote removes double quotes from a field. Quoted segments may appear anywhere in the field and
+// "" inside quotes stands for a literal quote. quoted reports whether the field contained quotes.
+func unquote(s string) (value string, quoted bool) {
+	if !strings.Contains(s, `"`) {
+		return s, false
+	}
+	var b strings.Builder
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '"' {
+			b.WriteByte(c)
+			continue
+		}
+		if inQuote && i+1 < len(s) && s[i+1] == '"' {
+			b.WriteByte('"')
+			i++
+			continue
+		}
+		inQuote = !inQuote
+	}
+	return b.String(), true
+}
+
+// splitList splits s at commas outside double quotes.
+func splitList(s string) []string {
+	var items []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			inQuote = !inQuote
+		case ',':
+			if !inQuote {
+				items = append(items, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, s[start:])
+}
+
+// FormatTokens joins tokens into a column value (comma-separated, no spaces), quoting as needed.
+func FormatTokens(tokens []Token) string {
+	parts := make([]string, len(tokens))
+	for i, t := range tokens {
+		parts[i] = t.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// containsToken reports whether any token matches q.
+func containsToken(tokens []Token, q Token) bool {
+	for _, t := range tokens {
+		if t.Matches(q) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenRank orders token kinds for sorting: keywords, then names, groups, files and regexes.
+var tokenRank = map[TokenKind]byte{
+	TokenKeyword: '0',
+	TokenName:    '1',
+	TokenGroup:   '2',
+	TokenFile:    '3',
+	TokenRegex:   '4',
+}
+
+// sortKey returns a key that sorts a column by token kind, then value, so that regex entries
+// ("/^x") do not sort before names because of their leading slash.
+func sortKey(tokens []Token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteByte(tokenRank[t.Kind])
+		b.WriteString(t.Value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
@@ -0,0 +1,155 @@
+package hba
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRule_tokens(t *testing.T) {
+	r := Rule{Type: "host", Database: "app1,sameuser,@dbs.txt", User: "alice,+ops,all,/^tenant_", Address: "10.0.0.0/8", Method: "md5"}
+	dbs := r.Databases()
+	wantDBs := []Token{{Kind: TokenName, Value: "app1"}, {Kind: TokenKeyword, Value: "sameuser"}, {Kind: TokenFile, Value: "dbs.txt"}}
+	if len(dbs) != len(wantDBs) {
+		t.Fatalf("databases: got %+v", dbs)
+	}
+	for i := range wantDBs {
+		if dbs[i] != wantDBs[i] {
+			t.Errorf("database %d: got %+v, want %+v", i, dbs[i], wantDBs[i])
+		}
+	}
+	users := r.Users()
+	wantUsers := []Token{{Kind: TokenName, Value: "alice"}, {Kind: TokenGroup, Value: "ops"}, {Kind: TokenKeyword, Value: "all"}, {Kind: TokenRegex, Value: "^tenant_"}}
+	if len(users) != len(wantUsers) {
+		t.Fatalf("users: got %+v", users)
+	}
+	for i := range wantUsers {
+		if users[i] != wantUsers[i] {
+			t.Errorf("user %d: got %+v, want %+v", i, users[i], wantUsers[i])
+		}
+	}
+	// "sameuser" is only a keyword in the database column.
+	if u := (Rule{User: "sameuser"}).Users(); u[0].Kind != TokenName {
+		t.Errorf("sameuser in user column: got %s", u[0].Kind)
+	}
+	if got := FormatTokens(users); got != r.User {
+		t.Errorf("FormatTokens: got %q, want %q", got, r.User)
+	}
+}
+
+func TestRule_MatchesUser_lists(t *testing.T) {
+	r := Rule{Type: "host", Database: "app1,app2", User: "alice,+ops", Address: "10.0.0.0/8", Method: "md5"}
+	tests := []struct {
+		user, db string
+		want     bool
+	}{
+		{"alice", "", true},
+		{"alice", "app2", true},
+		{"alice", "app3", false},
+		{"+ops", "", true},
+		{"ops", "", false},
+		{"bob", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := r.MatchesUser(tt.user, tt.db); got != tt.want {
+			t.Errorf("MatchesUser(%q, %q) = %v, want %v", tt.user, tt.db, got, tt.want)
+		}
+	}
+	if (Rule{User: "all"}).MatchesUser("alice", "") {
+		t.Error("keyword all should not match a specific user")
+	}
+}
+
+func TestRule_WithoutUser(t *testing.T) {
+	r := Rule{Type: "local", Database: "all", User: "alice,bob,alice", Method: "peer"}
+	out, ok := r.WithoutUser("alice")
+	if !ok || out.User != "bob" {
+		t.Errorf("WithoutUser(alice) = %q, %v", out.User, ok)
+	}
+	if _, ok := (Rule{User: "alice"}).WithoutUser("alice"); ok {
+		t.Error("WithoutUser on sole user should report ok=false")
+	}
+}
+
+func TestToken_quoting(t *testing.T) {
+	r := Rule{Type: "local", Database: `"all",app`, User: `"all","my user","a""b",all`, Method: "peer"}
+	users := r.Users()
+	want := []Token{
+		{Kind: TokenName, Value: "all", Quoted: true},
+		{Kind: TokenName, Value: "my user", Quoted: true},
+		{Kind: TokenName, Value: `a"b`, Quoted: true},
+		{Kind: TokenKeyword, Value: "all"},
+	}
+	if len(users) != len(want) {
+		t.Fatalf("users: got %+v", users)
+	}
+	for i := range want {
+		if users[i] != want[i] {
+			t.Errorf("user %d: got %+v, want %+v", i, users[i], want[i])
+		}
+	}
+	if got := FormatTokens(users); got != r.User {
+		t.Errorf("round trip: got %s, want %s", got, r.User)
+	}
+	// A quoted "all" is a user named all: it matches only that name, and the keyword does not match it.
+	if !r.MatchesUser(`"all"`, `"all"`) || r.MatchesUser("alice", "app") {
+		t.Error("quoted all should match only the name all")
+	}
+	if (Rule{User: "all"}).MatchesUser(`"all"`, "") {
+		t.Error("keyword all should not match the quoted name")
+	}
+	for in, want := range map[string]string{"alice": "alice", "a b": `"a b"`, "a,b": `"a,b"`, "all": `"all"`, "+x": `"+x"`, `q"t`: `"q""t"`} {
+		if got := (Token{Kind: TokenName, Value: in}).String(); got != want {
+			t.Errorf("String(%q) = %s, want %s", in, got, want)
+		}
+	}
+	// A regex with a comma must be quoted to stay one entry.
+	re := ParseUsers(`"/^a{1,3}$",bob`)
+	if len(re) != 2 || re[0].Kind != TokenRegex || re[0].Value != "^a{1,3}$" {
+		t.Errorf("quoted regex: got %+v", re)
+	}
+}
+
+func TestToken_regex(t *testing.T) {
+	r := Rule{Type: "host", Database: "/^tenant_[0-9]+$", User: "/^tenant_,admin", Address: "10.0.0.0/8", Method: "md5"}
+	tests := []struct {
+		user, db string
+		want     bool
+	}{
+		{"tenant_a", "", true},
+		{"a_tenant_a", "", false}, // ^ anchors at the start only
+		{"Tenant_a", "", false},   // case-sensitive
+		{"/^tenant_", "", true},   // the entry as written
+		{"tenant_a", "tenant_42", true},
+		{"tenant_a", "tenant_42x", false},
+		{"admin", "tenant_1", true},
+	}
+	for _, tt := range tests {
+		if got := r.MatchesUser(tt.user, tt.db); got != tt.want {
+			t.Errorf("MatchesUser(%q, %q) = %v, want %v", tt.user, tt.db, got, tt.want)
+		}
+	}
+	if _, err := (Token{Kind: TokenRegex, Value: `^(a)\1$`}).Regexp(); err == nil {
+		t.Error("back-reference should be reported as unsupported")
+	}
+	if out, ok := r.WithoutUser("tenant_a"); !ok || out.User != r.User {
+		t.Errorf("WithoutUser must keep regex entries: got %q, %v", out.User, ok)
+	}
+}
+
+func TestSortRules_regexAfterNames(t *testing.T) {
+	rules := []Rule{
+		{Type: "local", Database: "all", User: "/^z", Method: "trust"},
+		{Type: "local", Database: "all", User: "bob", Method: "trust"},
+		{Type: "local", Database: "all", User: "all", Method: "trust"},
+		{Type: "local", Database: "all", User: "+ops", Method: "trust"},
+	}
+	SortRules(rules, "user")
+	var got []string
+	for _, r := range rules {
+		got = append(got, r.User)
+	}
+	if got, want := strings.Join(got, " "), "all bob +ops /^z"; got != want {
+		t.Errorf("sorted users: got %q, want %q", got, want)
+	}
+}
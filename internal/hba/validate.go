@@ -0,0 +1,400 @@
+package hba
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// methods are the authentication methods PostgreSQL accepts.
+var methods = map[string]bool{
+	"trust":         true,
+	"reject":        true,
+	"scram-sha-256": true,
+	"md5":           true,
+	"password":      true,
+	"gss":           true,
+	"sspi":          true,
+	"ident":         true,
+	"peer":          true,
+	"ldap":          true,
+	"radius":        true,
+	"cert":          true,
+	"pam":           true,
+	"bsd":           true,
+}
+
+// optionMethods maps each authentication option to the methods it is valid for. A nil entry means
+// any method (the option is restricted by connection type instead).
+var optionMethods = map[string][]string{
+	"map":                 {"ident", "peer", "gss", "sspi", "cert"},
+	"clientcert":          nil,
+	"clientname":          nil,
+	"pamservice":          {"pam"},
+	"pam_use_hostname":    {"pam"},
+	"ldapurl":             {"ldap"},
+	"ldaptls":             {"ldap"},
+	"ldapscheme":          {"ldap"},
+	"ldapserver":          {"ldap"},
+	"ldapport":            {"ldap"},
+	"ldapbinddn":          {"ldap"},
+	"ldapbindpasswd":      {"ldap"},
+	"ldapsearchattribute": {"ldap"},
+	"ldapsearchfilter":    {"ldap"},
+	"ldapbasedn":          {"ldap"},
+	"ldapprefix":          {"ldap"},
+	"ldapsuffix":          {"ldap"},
+	"krb_realm":           {"gss", "sspi"},
+	"include_realm":       {"gss", "sspi"},
+	"compat_realm":        {"sspi"},
+	"upn_username":        {"sspi"},
+	"radiusservers":       {"radius"},
+	"radiussecrets":       {"radius"},
+	"radiusidentifiers":   {"radius"},
+	"radiusports":         {"radius"},
+}
+
+// problem is a reason PostgreSQL would reject a rule. field is the index of the offending field in
+// Rule.columns, or -1 when the problem concerns the rule as a whole.
+type problem struct {
+	field int
+	msg   string
+}
+
+// Validate checks the document without a server: it returns the lines that could not be parsed and
+// the rules that parse but that PostgreSQL (of version d.Version) would reject when loading the
+// file, in file order.
+func (d *Document) Validate() []Diagnostic { return d.check(true) }
+
+// check returns the diagnostics of the document; with rules set, also the problems of each rule.
+func (d *Document) check(rules bool) []Diagnostic {
+	var diags []Diagnostic
+	line := 1
+	for _, n := range d.nodes {
+		at := func(col int, msg string) {
+			diags = append(diags, Diagnostic{File: d.Path, Line: line, Column: col, Message: msg, Text: diagnosticText(n)})
+		}
+		if n.Lines() > 1 && !d.Version.Supports(FeatureContinuation) {
+			at(1, d.Version.unsupported(FeatureContinuation))
+		}
+		switch n.Kind {
+		case NodeInvalid:
+			at(n.column, n.problem)
+		case NodeDirective:
+			if !d.Version.Supports(FeatureInclude) {
+				at(1, d.Version.unsupported(FeatureInclude))
+			}
+		case NodeRule:
+			if !rules {
+				break
+			}
+			offsets := n.fieldOffsets()
+			for _, p := range n.Rule.problems(d.Version) {
+				col := 1
+				if p.field >= 0 && p.field < len(offsets) {
+					col = offsets[p.field] + 1
+				}
+				at(col, p.msg)
+			}
+		}
+		line += n.Lines()
+	}
+	return diags
+}
+
+// Validate returns the problems PostgreSQL v would find in r (nil if none).
+func (r Rule) Validate(v Version) []error {
+	var errs []error
+	for _, p := range r.problems(v) {
+		errs = append(errs, errors.New(p.msg))
+	}
+	return errs
+}
+
+// Validate checks every loaded file (see Document.Validate), in load order.
+func (c *Config) Validate() []Diagnostic {
+	var diags []Diagnostic
+	for _, d := range c.Docs {
+		diags = append(diags, d.Validate()...)
+	}
+	return diags
+}
+
+// fieldOffsets returns the offset of each field of the node's logical line (continuations joined).
+// Rendered nodes have no original text and are measured as rendered.
+func (n *Node) fieldOffsets() []int {
+	text := n.raw
+	if text == "" {
+		text = n.Text()
+	}
+	var logical strings.Builder
+	for _, ln := range strings.Split(text, "\n") {
+		logical.WriteString(strings.TrimSuffix(strings.TrimSuffix(ln, "\r"), "\\"))
+	}
+	body, _ := splitComment(logical.String())
+	return fieldOffsets(body)
+}
+
+// problems returns the reasons PostgreSQL would reject r, mirroring the checks it makes when it
+// loads pg_hba.conf (see hba.c, parse_hba_line and parse_hba_auth_opt).
+func (r Rule) problems(v Version) []problem {
+	var probs []problem
+	cols := r.columns()
+	fieldOf := func(col int) int {
+		for i, c := range cols {
+			if c.col == col {
+				return i
+			}
+		}
+		return -1
+	}
+	add := func(col int, format string, args ...any) {
+		probs = append(probs, problem{fieldOf(col), fmt.Sprintf(format, args...)})
+	}
+	typ := strings.ToLower(r.Type)
+	if hostTypes[typ] && strings.HasSuffix(typ, "gssenc") && !v.Supports(FeatureGSSEnc) {
+		add(colType, "%s", v.unsupported(FeatureGSSEnc))
+	}
+
+	for _, t := range [2]struct {
+		col    int
+		name   string
+		tokens []Token
+	}{{colDatabase, "database", r.Databases()}, {colUser, "role", r.Users()}} {
+		for _, tok := range t.tokens {
+			switch {
+			case tok.Kind == TokenName && tok.Value == "" && !tok.Quoted:
+				add(t.col, "empty entry in %s list", t.name)
+			case tok.Kind == TokenRegex && !v.Supports(FeatureRegex):
+				add(t.col, "%s; %q would be read as a name", v.unsupported(FeatureRegex), tok.String())
+			case tok.Kind == TokenKeyword && tok.Value == "samegroup" && !v.Supports(FeatureSameGroup):
+				add(t.col, "%s", v.unsupported(FeatureSameGroup))
+			case tok.Kind == TokenRegex:
+				if _, err := tok.Regexp(); err != nil {
+					add(t.col, "invalid regular expression %q: %v", tok.Value, err)
+				}
+			}
+		}
+	}
+
+	if hostTypes[typ] {
+		if msg := addressProblem(r.Address, r.Netmask); msg != "" {
+			add(colAddress, "%s", msg)
+		}
+		if msg := netmaskProblem(r.Address, r.Netmask); msg != "" {
+			add(colNetmask, "%s", msg)
+		}
+	}
+
+	method := r.Method
+	if !methods[method] {
+		add(colMethod, "invalid authentication method %q", method)
+		return probs
+	}
+	if method == "scram-sha-256" && !v.Supports(FeatureScram) {
+		add(colMethod, "%s", v.unsupported(FeatureScram))
+	}
+	if typ == "local" && method == "ident" {
+		method = "peer" // PostgreSQL treats ident on local sockets as peer
+	}
+	switch {
+	case typ == "local" && (method == "gss" || method == "sspi"):
+		add(colMethod, "%s authentication is not supported on local sockets", method)
+	case typ != "local" && method == "peer":
+		add(colMethod, "peer authentication is only supported on local sockets")
+	case typ != "hostssl" && method == "cert":
+		add(colMethod, "cert authentication is only supported on hostssl connections")
+	}
+
+	optField := fieldOf(colOptions)
+	optProblem := func(i int, format string, args ...any) {
+		probs = append(probs, problem{optField + i, fmt.Sprintf(format, args...)})
+	}
+	for i, o := range r.Options {
+		allowed, known := optionMethods[o.Name]
+		switch {
+		case o.NoValue:
+			optProblem(i, "authentication option not in name=value format: %s", o.Name)
+		case !known:
+			optProblem(i, "unrecognized authentication option name: %q", o.Name)
+		case allowed != nil && !slices.Contains(allowed, method):
+			optProblem(i, "authentication option %q is only valid for authentication methods %s", o.Name, strings.Join(allowed, ", "))
+		case o.Name == "clientcert" || o.Name == "clientname":
+			if typ != "hostssl" {
+				optProblem(i, "%s can only be configured for \"hostssl\" rows", o.Name)
+			} else if o.Name == "clientname" && !v.Supports(FeatureClientName) {
+				optProblem(i, "%s", v.unsupported(FeatureClientName))
+			} else if o.Name == "clientname" && o.Value != "CN" && o.Value != "DN" {
+				optProblem(i, "invalid value for clientname: %q", o.Value)
+			} else if o.Name == "clientcert" {
+				if msg := clientCertProblem(o.Value, method, v); msg != "" {
+					optProblem(i, "%s", msg)
+				}
+			}
+		case o.Name == "ldapscheme" && o.Value != "ldap" && o.Value != "ldaps":
+			optProblem(i, "invalid ldapscheme value: %q", o.Value)
+		case o.Name == "ldapport" || o.Name == "radiusports":
+			for _, port := range strings.Split(o.Value, ",") {
+				if !isPort(port) {
+					optProblem(i, "invalid %s number: %q", o.Name, port)
+					break
+				}
+			}
+		}
+	}
+
+	has := func(name string) bool { _, ok := r.Option(name); return ok }
+	switch method {
+	case "ldap":
+		if !has("ldapserver") && !has("ldapurl") {
+			add(colMethod, "authentication method \"ldap\" requires argument \"ldapserver\" to be set")
+		}
+		simple := has("ldapprefix") || has("ldapsuffix")
+		search := has("ldapbasedn") || has("ldapbinddn") || has("ldapbindpasswd") || has("ldapsearchattribute") || has("ldapsearchfilter")
+		switch {
+		case simple && search:
+			add(colMethod, "cannot mix options for simple bind and search+bind modes")
+		case !simple && !search && !has("ldapurl"):
+			add(colMethod, "authentication method \"ldap\" requires argument \"ldapbasedn\", \"ldapprefix\", or \"ldapsuffix\" to be set")
+		}
+	case "radius":
+		for _, name := range []string{"radiusservers", "radiussecrets"} {
+			if !has(name) {
+				add(colMethod, "authentication method \"radius\" requires argument %q to be set", name)
+			}
+		}
+	}
+	return probs
+}
+
+// addressProblem checks the address column of a host rule: a CIDR address, an IP address followed
+// by a netmask, a host name (optionally starting with a dot) or one of all, samehost, samenet.
+func addressProblem(addr, netmask string) string {
+	switch addr {
+	case "all", "samehost", "samenet":
+		if netmask != "" {
+			return fmt.Sprintf("netmask not allowed with %s", addr)
+		}
+		return ""
+	case "", "-":
+		return "end-of-line before IP address specification"
+	}
+	host, _, cidr := strings.Cut(addr, "/")
+	ip := net.ParseIP(host)
+	if cidr {
+		if ip == nil {
+			if isHostName(host) {
+				return "specifying both host name and CIDR mask is invalid"
+			}
+			return fmt.Sprintf("invalid IP address %q", host)
+		}
+		if _, _, err := net.ParseCIDR(addr); err != nil {
+			return fmt.Sprintf("invalid CIDR mask in address %q", addr)
+		}
+		return ""
+	}
+	if ip == nil {
+		if !isHostName(addr) {
+			return fmt.Sprintf("invalid IP address %q", addr)
+		}
+		if netmask != "" {
+			return "specifying both host name and netmask is invalid"
+		}
+		return ""
+	}
+	if netmask == "" {
+		return fmt.Sprintf("IP address %q needs a CIDR mask (e.g. %s/%d) or a netmask", addr, addr, fullMask(ip))
+	}
+	return ""
+}
+
+// netmaskProblem checks the legacy netmask column against the address it applies to.
+func netmaskProblem(addr, netmask string) string {
+	if netmask == "" {
+		return ""
+	}
+	if strings.Contains(addr, "/") {
+		return "netmask not allowed with CIDR address"
+	}
+	ip, mask := net.ParseIP(addr), net.ParseIP(netmask)
+	if ip == nil {
+		return ""
+	}
+	if mask == nil {
+		return fmt.Sprintf("invalid IP mask %q", netmask)
+	}
+	if (ip.To4() == nil) != (mask.To4() == nil) {
+		return "IP address and mask do not match"
+	}
+	return ""
+}
+
+// fullMask returns the number of bits of a host mask for ip (32 or 128).
+func fullMask(ip net.IP) int {
+	if ip.To4() != nil {
+		return 32
+	}
+	return 128
+}
+
+// isHostName reports whether s can be a host name (letters, digits, '-', '_' and dots; a leading
+// dot matches a domain suffix).
+func isHostName(s string) bool {
+	if s == "" || s == "." {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// isPort reports whether s is a TCP/UDP port number.
+func isPort(s string) bool {
+	n := 0
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+		n = n*10 + int(c-'0')
+		if n > 65535 {
+			return false
+		}
+	}
+	return s != "" && n > 0
+}
+
+// clientCertProblem checks the value of the clientcert option of a hostssl rule for PostgreSQL v:
+// 0 and 1 until 13, verify-ca and verify-full from 12, no-verify in 12 and 13. Unlike other
+// features, the values removed in 14 are rejected for an unknown (latest) version. It returns why
+// PostgreSQL would reject it, or "".
+func clientCertProblem(value, method string, v Version) string {
+	switch value {
+	case "verify-ca", "verify-full":
+		if !v.Supports(FeatureClientCert) {
+			return v.unsupported(FeatureClientCert) + "; use clientcert=1"
+		}
+	case "0", "1":
+		if v == 0 || !v.Supports(FeatureClientCertLegacy) {
+			return v.unsupported(FeatureClientCertLegacy) + "; use clientcert=verify-ca or verify-full"
+		}
+	case "no-verify":
+		if v == 0 || !v.Supports(FeatureClientCertNoVerify) {
+			return v.unsupported(FeatureClientCertNoVerify) + "; leave clientcert out"
+		}
+	default:
+		return fmt.Sprintf("invalid value for clientcert: %q", value)
+	}
+	switch {
+	case method != "cert":
+	case value == "0" || value == "no-verify":
+		return fmt.Sprintf("clientcert can not be set to %q when using \"cert\" authentication", value)
+	case value != "verify-full" && (v == 0 || v >= 14):
+		return "clientcert only accepts \"verify-full\" when using \"cert\" authentication"
+	}
+	return ""
+}
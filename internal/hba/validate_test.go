@@ -0,0 +1,73 @@
+package hba
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRule_problems(t *testing.T) {
+	tests := []struct {
+		line string
+		want string // substring of the first problem; empty when the rule is valid
+	}{
+		{"local all all trust", ""},
+		{"local all all ident map=m", ""},
+		{"host all all 10.0.0.0/8 scram-sha-256", ""},
+		{"host all all 10.0.0.1 255.255.255.255 md5", ""},
+		{"host all all .example.com md5", ""},
+		{"host all all samenet md5", ""},
+		{"hostssl all all ::1/128 cert clientcert=verify-full map=certmap", ""},
+		{`host all all 10.0.0.0/8 ldap ldapserver=ldap.example.com ldapprefix="cn=" ldapsuffix=", dc=example"`, ""},
+		{"host all all 10.0.0.0/8 radius radiusservers=a radiussecrets=s", ""},
+		{`host all all 10.0.0.0/8 ldap ldapserver=x ldapprefix="" ldapsuffix=",dc=x"`, ""},
+		{"host all all 10.0.0.1 md5", "needs a CIDR mask"},
+		{"host all all 10.0.0.0/33 md5", "invalid CIDR mask"},
+		{"host all all 10.0.0.0/8 255.0.0.0 md5", "netmask not allowed with CIDR"},
+		{"host all all ::1 255.255.255.255 md5", "do not match"},
+		{"host all all example.com/24 md5", "host name and CIDR mask"},
+		{"host all all 10.0.0.0/8 md6", `invalid authentication method "md6"`},
+		{"host all all 10.0.0.0/8 peer", "only supported on local sockets"},
+		{"local all all gss", "not supported on local sockets"},
+		{"host all all 10.0.0.0/8 cert", "only supported on hostssl"},
+		{"host all all 10.0.0.0/8 md5 clientcert=verify-full", `only be configured for "hostssl"`},
+		{"host all all 10.0.0.0/8 md5 map=m", `"map" is only valid`},
+		{"local all all peer foo=bar", "unrecognized authentication option"},
+		{"local all all peer map", "not in name=value format"},
+		{"host all all 10.0.0.0/8 ldap ldapbasedn=dc=x", `requires argument "ldapserver"`},
+		{"host all all 10.0.0.0/8 ldap ldapserver=x ldapprefix=a ldapbasedn=b", "cannot mix"},
+		{"host all all 10.0.0.0/8 ldap ldapserver=x ldapport=99999 ldapbasedn=b", "invalid ldapport number"},
+		{"host all all 10.0.0.0/8 radius radiusservers=a", `"radiussecrets"`},
+		{"local all /^(a)\\1$ trust", "invalid regular expression"},
+		{"local app1,,app2 all trust", "empty entry in database list"},
+	}
+	for _, tt := range tests {
+		r, ok := parseLine(tt.line)
+		if !ok {
+			t.Fatalf("parseLine(%q) failed", tt.line)
+		}
+		probs := r.problems(0)
+		switch {
+		case tt.want == "" && len(probs) > 0:
+			t.Errorf("%q: unexpected problems %+v", tt.line, probs)
+		case tt.want != "" && len(probs) == 0:
+			t.Errorf("%q: no problem, want %q", tt.line, tt.want)
+		case tt.want != "" && !strings.Contains(probs[0].msg, tt.want):
+			t.Errorf("%q: got %q, want %q", tt.line, probs[0].msg, tt.want)
+		}
+	}
+}
+
+func TestDocument_Validate(t *testing.T) {
+	d := parseDocument("# comment\nlocal all all trust\nhots all all 10.0.0.1/32 md5\nhost all all 10.0.0.0/8 peer  # oops\n")
+	d.Path = "pg_hba.conf"
+	diags := d.Validate()
+	if len(diags) != 2 {
+		t.Fatalf("got %+v", diags)
+	}
+	if got := diags[0].String(); got != `pg_hba.conf:3:1: invalid connection type "hots"` {
+		t.Errorf("diagnostic 0: %s", got)
+	}
+	if diags[1].Line != 4 || diags[1].Column != 25 || diags[1].Text != "host all all 10.0.0.0/8 peer  # oops" {
+		t.Errorf("diagnostic 1: %+v", diags[1])
+	}
+}
@@ -0,0 +1,106 @@
+package hba
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a PostgreSQL major version (e.g. 16). The zero value means the target version is not
+// known: every construct hbactl understands is accepted.
+type Version int
+
+// Feature is a pg_hba.conf construct that is only accepted by some PostgreSQL versions.
+type Feature int
+
+const (
+	FeatureScram              Feature = iota // scram-sha-256 method (10+)
+	FeatureGSSEnc                            // hostgssenc / hostnogssenc connection types (12+)
+	FeatureClientCert                        // clientcert=verify-ca / verify-full (12+)
+	FeatureClientCertLegacy                  // clientcert=0 / 1 (before 14)
+	FeatureClientCertNoVerify                // clientcert=no-verify (12 and 13)
+	FeatureClientName                        // clientname option (14+)
+	FeatureRegex                             // regular expressions in database and user columns (16+)
+	FeatureInclude                           // include, include_if_exists and include_dir (16+)
+	FeatureContinuation                      // backslash line continuation (16+)
+	FeatureSameGroup                         // samegroup, the obsolete spelling of samerole (before 16)
+)
+
+// featureVersions gives, for each feature, the first version that accepts it and the first version
+// that no longer does (0 when still accepted).
+var featureVersions = map[Feature]struct{ since, until Version }{
+	FeatureScram:              {since: 10},
+	FeatureGSSEnc:             {since: 12},
+	FeatureClientCert:         {since: 12},
+	FeatureClientCertLegacy:   {until: 14},
+	FeatureClientCertNoVerify: {since: 12, until: 14},
+	FeatureClientName:         {since: 14},
+	FeatureRegex:              {since: 16},
+	FeatureInclude:            {since: 16},
+	FeatureContinuation:       {since: 16},
+	FeatureSameGroup:          {until: 16},
+}
+
+// featureNames describe each feature in messages.
+var featureNames = map[Feature]string{
+	FeatureScram:              "scram-sha-256 authentication",
+	FeatureGSSEnc:             "connection type hostgssenc/hostnogssenc",
+	FeatureClientCert:         "clientcert=verify-ca/verify-full",
+	FeatureClientCertLegacy:   "clientcert=0/1",
+	FeatureClientCertNoVerify: "clientcert=no-verify",
+	FeatureClientName:         "option clientname",
+	FeatureRegex:              "a regular expression in the database or user column",
+	FeatureInclude:            "an include directive",
+	FeatureContinuation:       "backslash line continuation",
+	FeatureSameGroup:          "keyword samegroup (use samerole)",
+}
+
+// ParseVersion parses a PostgreSQL version as a major version ("16", "9.6"), a full version
+// ("16.2") or a server_version_num ("160002", "90624").
+func ParseVersion(s string) (Version, error) {
+	s = strings.TrimSpace(s)
+	major, _, _ := strings.Cut(s, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid PostgreSQL version %q (e.g. 16, 16.2 or 160002)", s)
+	}
+	if n >= 10000 && !strings.Contains(s, ".") {
+		return VersionFromNum(n), nil
+	}
+	if n < 7 {
+		return 0, fmt.Errorf("unsupported PostgreSQL version %q", s)
+	}
+	return Version(n), nil
+}
+
+// VersionFromNum converts a server_version_num (e.g. 160002) to a major version. Versions before 10
+// (e.g. 90624) map to their first number (9).
+func VersionFromNum(num int) Version {
+	return Version(num / 10000)
+}
+
+// String returns the version as PostgreSQL writes it ("16"), or "latest" when unknown.
+func (v Version) String() string {
+	if v == 0 {
+		return "latest"
+	}
+	return strconv.Itoa(int(v))
+}
+
+// Supports reports whether PostgreSQL v accepts f. An unknown version supports everything.
+func (v Version) Supports(f Feature) bool {
+	if v == 0 {
+		return true
+	}
+	fv := featureVersions[f]
+	return v >= fv.since && (fv.until == 0 || v < fv.until)
+}
+
+// unsupported returns the message for using f with v.
+func (v Version) unsupported(f Feature) string {
+	fv := featureVersions[f]
+	if fv.until != 0 && (v == 0 || v >= fv.until) {
+		return fmt.Sprintf("%s is not accepted by PostgreSQL %d and later (target version %s)", featureNames[f], fv.until, v)
+	}
+	return fmt.Sprintf("%s requires PostgreSQL %d or later (target version %s)", featureNames[f], fv.since, v)
+}
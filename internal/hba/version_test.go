@@ -0,0 +1,108 @@
+package hba
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Version
+	}{
+		{"16", 16},
+		{"16.2", 16},
+		{"9.6", 9},
+		{"160002", 16},
+		{"90624", 9},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseVersion(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "x", "-1", "5"} {
+		if _, err := ParseVersion(in); err == nil {
+			t.Errorf("ParseVersion(%q) should fail", in)
+		}
+	}
+	if !Version(0).Supports(FeatureRegex) || Version(15).Supports(FeatureRegex) || !Version(16).Supports(FeatureRegex) {
+		t.Error("FeatureRegex gating")
+	}
+	if !Version(15).Supports(FeatureSameGroup) || Version(16).Supports(FeatureSameGroup) {
+		t.Error("FeatureSameGroup gating")
+	}
+}
+
+func TestRule_Validate_version(t *testing.T) {
+	tests := []struct {
+		line string
+		v    Version
+		want string // substring of the first error; empty when accepted
+	}{
+		{"hostgssenc all all 10.0.0.0/8 gss", 11, "requires PostgreSQL 12"},
+		{"hostgssenc all all 10.0.0.0/8 gss", 12, ""},
+		{"local all /^tenant_ trust", 15, "requires PostgreSQL 16"},
+		{"local all /^tenant_ trust", 16, ""},
+		{"host all all 10.0.0.0/8 scram-sha-256", 9, "requires PostgreSQL 10"},
+		{"host all all 10.0.0.0/8 password", 9, ""},
+		{"local samegroup all trust", 15, ""},
+		{"local samegroup all trust", 16, "not accepted by PostgreSQL 16"},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=1", 11, ""},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=verify-ca", 11, "requires PostgreSQL 12"},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=no-verify", 11, "requires PostgreSQL 12"},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=1", 12, ""},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=0", 12, ""},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=no-verify", 12, ""},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=verify-ca", 12, ""},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=1", 13, ""},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=no-verify", 13, ""},
+		{"hostssl all all 10.0.0.0/8 cert clientcert=verify-ca", 13, ""},
+		{"hostssl all all 10.0.0.0/8 cert clientcert=no-verify", 13, "can not be set"},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=1", 14, "not accepted by PostgreSQL 14"},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=0", 14, "not accepted by PostgreSQL 14"},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=no-verify", 14, "not accepted by PostgreSQL 14"},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=verify-ca", 14, ""},
+		{"hostssl all all 10.0.0.0/8 cert clientcert=verify-ca", 14, "only accepts \"verify-full\""},
+		{"hostssl all all 10.0.0.0/8 md5 clientcert=1", 0, "not accepted by PostgreSQL 14"},
+		{"hostssl all all 10.0.0.0/8 md5 clientname=DN", 13, "requires PostgreSQL 14"},
+	}
+	for _, tt := range tests {
+		r, _ := parseLine(tt.line)
+		errs := r.Validate(tt.v)
+		switch {
+		case tt.want == "" && len(errs) > 0:
+			t.Errorf("%q (v%d): unexpected %v", tt.line, tt.v, errs)
+		case tt.want != "" && (len(errs) == 0 || !strings.Contains(errs[0].Error(), tt.want)):
+			t.Errorf("%q (v%d): got %v, want %q", tt.line, tt.v, errs, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigVersion_includeNotFollowed(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"pg_hba.conf": "local all all peer\ninclude extra.conf\nhost all all 10.0.0.0/8 \\\n  md5\n",
+		"extra.conf":  "local all app trust\n",
+	})
+	cfg, err := LoadConfigVersion(filepath.Join(dir, "pg_hba.conf"), 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Docs) != 1 || len(cfg.Rules()) != 2 {
+		t.Errorf("include should not be followed for 15: %d docs, %d rules", len(cfg.Docs), len(cfg.Rules()))
+	}
+	diags := cfg.Diagnostics()
+	if len(diags) != 2 || diags[0].Line != 2 || diags[1].Line != 3 {
+		t.Fatalf("diagnostics: %+v", diags)
+	}
+	if !strings.Contains(diags[1].Message, "continuation") {
+		t.Errorf("diagnostic 1: %s", diags[1].Message)
+	}
+	cfg, err = LoadConfigVersion(filepath.Join(dir, "pg_hba.conf"), 16)
+	if err != nil || len(cfg.Docs) != 2 || len(cfg.Diagnostics()) != 0 {
+		t.Errorf("16: %v, %d docs", err, len(cfg.Docs))
+	}
+}
@@ -0,0 +1,48 @@
+package hba
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+)
+
+// readXattrs returns the extended attributes of path (nil if the file system has none).
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, ignoreNoXattr(err)
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, ignoreNoXattr(err)
+	}
+	attrs := make(map[string][]byte)
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		n, err := syscall.Getxattr(path, string(name), nil)
+		if err != nil {
+			return nil, ignoreNoXattr(err)
+		}
+		value := make([]byte, n)
+		if n, err = syscall.Getxattr(path, string(name), value); err != nil {
+			return nil, ignoreNoXattr(err)
+		}
+		attrs[string(name)] = value[:n]
+	}
+	return attrs, nil
+}
+
+// writeXattr sets the extended attribute name on path.
+func writeXattr(path, name string, value []byte) error {
+	return ignoreNoXattr(syscall.Setxattr(path, name, value, 0))
+}
+
+// ignoreNoXattr drops the error of a file system that does not support extended attributes.
+func ignoreNoXattr(err error) error {
+	if errors.Is(err, syscall.ENOTSUP) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,27 @@
+package hba
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFileAtomic_keepsXattrs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pg_hba.conf")
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(path, "user.hbactl", []byte("kept"), 0); err != nil {
+		t.Skipf("extended attributes not supported here: %v", err)
+	}
+	if err := WriteFileAtomic(path, []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := syscall.Getxattr(path, "user.hbactl", buf)
+	if err != nil || string(buf[:n]) != "kept" {
+		t.Errorf("xattr not kept: %q, %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package hba
+
+// readXattrs is only implemented on Linux.
+func readXattrs(string) (map[string][]byte, error) { return nil, nil }
+
+// writeXattr is only implemented on Linux.
+func writeXattr(string, string, []byte) error { return nil }
@@ -0,0 +1,158 @@
+// Package journal records the changes hbactl makes to pg_hba.conf (and the files it includes or
+// references) in an append-only file of JSON lines, one entry per command run.
+package journal
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hrodrig/hbactl/internal/diff"
+)
+
+// Entry is one command run that changed files.
+type Entry struct {
+	ID       string       `json:"id"`
+	Time     time.Time    `json:"time"`
+	User     string       `json:"user"`                // OS user running hbactl
+	SudoUser string       `json:"sudo_user,omitempty"` // SUDO_USER, the user behind sudo
+	Host     string       `json:"host,omitempty"`
+	Command  []string     `json:"command"`
+	Reason   string       `json:"reason,omitempty"`
+	Files    []FileChange `json:"files"`
+	Undoes   []string     `json:"undoes,omitempty"` // IDs of the entries this run reverted (hbactl undo)
+}
+
+// FileChange is the change made to one file.
+type FileChange struct {
+	Path    string   `json:"path"`
+	Before  string   `json:"before"` // SHA-256 of the content before the change
+	After   string   `json:"after"`  // SHA-256 of the content after the change
+	Backup  string   `json:"backup,omitempty"`
+	Added   []string `json:"added,omitempty"`   // rules (and other non-comment lines) added
+	Removed []string `json:"removed,omitempty"` // rules (and other non-comment lines) removed
+}
+
+// NewEntry returns an entry for the current process, run as command, without changes. Secrets in
+// command are masked (see Redact).
+func NewEntry(command []string, reason string) Entry {
+	masked := make([]string, len(command))
+	for i, arg := range command {
+		masked[i] = Redact(arg)
+	}
+	e := Entry{ID: newID(), Time: time.Now().UTC(), SudoUser: os.Getenv("SUDO_USER"), Command: masked, Reason: reason}
+	if u, err := user.Current(); err == nil {
+		e.User = u.Username
+	}
+	e.Host, _ = os.Hostname()
+	return e
+}
+
+// newID returns a random 16-character identifier.
+func newID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Hash returns the hex SHA-256 of data, as recorded in FileChange.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Change describes the change of the file at path from before to after; backup is where before was
+// saved. Secrets in the lines added and removed are masked (see Redact).
+func Change(path string, before, after []byte, backup string) FileChange {
+	c := FileChange{Path: path, Before: Hash(before), After: Hash(after), Backup: backup}
+	for _, e := range diff.Edits(diff.Lines(string(before)), diff.Lines(string(after))) {
+		line := Redact(strings.TrimSpace(e.Text))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch e.Op {
+		case diff.Insert:
+			c.Added = append(c.Added, line)
+		case diff.Delete:
+			c.Removed = append(c.Removed, line)
+		}
+	}
+	return c
+}
+
+// redacted replaces a secret in the journal.
+const redacted = "***"
+
+var (
+	// secretOption matches the value of an authentication option holding a password or shared
+	// secret, quoted or not.
+	secretOption = regexp.MustCompile(`(?i)\b(ldapbindpasswd|radiussecrets)=("(?:[^"]|"")*"|[^\s#]*)`)
+	// connPassword matches the password of a key/value connection string (password=...).
+	connPassword = regexp.MustCompile(`(?i)\b(password)\s*=\s*('(?:[^'\\]|\\.)*'|[^\s']*)`)
+	// uriPassword matches the password of a postgres:// connection URI.
+	uriPassword = regexp.MustCompile(`(?i)(postgres(?:ql)?://[^:/@\s]*:)[^@\s]*@`)
+)
+
+// Redact masks secrets in s, a rule line or a command-line argument, so that they do not end up in
+// the journal or syslog: the values of ldapbindpasswd and radiussecrets, and the password of a
+// connection string or URI (e.g. --conn).
+func Redact(s string) string {
+	s = secretOption.ReplaceAllString(s, "${1}="+redacted)
+	s = connPassword.ReplaceAllString(s, "${1}="+redacted)
+	return uriPassword.ReplaceAllString(s, "${1}"+redacted+"@")
+}
+
+// Append adds e to the journal file at path as one JSON line, creating the file if needed.
+func Append(path string, e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read returns the entries of the journal file at path, oldest first. A missing file has none.
+func Read(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []Entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
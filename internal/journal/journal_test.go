@@ -0,0 +1,117 @@
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChange(t *testing.T) {
+	before := "# comment\nlocal all all peer\nhost all app 10.0.0.0/8 md5\n"
+	after := "# comment changed\nlocal all all peer\nhost all app 10.0.0.0/8 scram-sha-256\n\n"
+	c := Change("/etc/pg_hba.conf", []byte(before), []byte(after), "/etc/pg_hba.conf.bak.x")
+	if c.Before != Hash([]byte(before)) || c.After != Hash([]byte(after)) {
+		t.Error("hashes do not match the contents")
+	}
+	if want := []string{"host all app 10.0.0.0/8 scram-sha-256"}; !reflect.DeepEqual(c.Added, want) {
+		t.Errorf("Added: got %q, want %q", c.Added, want)
+	}
+	if want := []string{"host all app 10.0.0.0/8 md5"}; !reflect.DeepEqual(c.Removed, want) {
+		t.Errorf("Removed: got %q, want %q", c.Removed, want)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{`host all all 10.0.0.0/8 ldap ldapserver=x ldapbinddn=cn=svc ldapbindpasswd=SECRET ldapsearchattribute=uid`,
+			`host all all 10.0.0.0/8 ldap ldapserver=x ldapbinddn=cn=svc ldapbindpasswd=*** ldapsearchattribute=uid`},
+		{`host all all 10.0.0.0/8 ldap ldapbindpasswd="my secret" # note`, `host all all 10.0.0.0/8 ldap ldapbindpasswd=*** # note`},
+		{`host all all 10.0.0.0/8 radius radiusservers=a,b radiussecrets=s1,s2`, `host all all 10.0.0.0/8 radius radiusservers=a,b radiussecrets=***`},
+		{"--option=ldapbindpasswd=SECRET", "--option=ldapbindpasswd=***"},
+		{"host=db user=admin password=SECRET dbname=postgres", "host=db user=admin password=*** dbname=postgres"},
+		{"host=db password = 'a b' dbname=postgres", "host=db password=*** dbname=postgres"},
+		{"postgres://admin:SECRET@db:5432/postgres", "postgres://admin:***@db:5432/postgres"},
+		{"postgresql://admin@db/postgres", "postgresql://admin@db/postgres"},
+		{"local all all peer", "local all all peer"},
+	} {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	before := "local all all peer\n"
+	after := before + "host all all 10.0.0.0/8 ldap ldapserver=x ldapbindpasswd=SECRET ldapbasedn=dc=x\n"
+	c := Change("/etc/pg_hba.conf", []byte(before), []byte(after), "")
+	e := NewEntry([]string{"hbactl", "-c", "postgres://a:SECRET@db/postgres", "add", "--option", "ldapbindpasswd=SECRET"}, "")
+	e.Files = []FileChange{c}
+	line, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(line), "SECRET") {
+		t.Errorf("journal entry holds a secret: %s", line)
+	}
+}
+
+func TestAppendRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pg_hba.conf.journal")
+	if entries, err := Read(path); err != nil || entries != nil {
+		t.Fatalf("missing journal: got %v, %v", entries, err)
+	}
+	e1 := NewEntry([]string{"hbactl", "add"}, "TICKET-1")
+	e1.Files = []FileChange{{Path: "/etc/pg_hba.conf", Before: "a", After: "b"}}
+	e2 := NewEntry([]string{"hbactl", "undo"}, "")
+	e2.Undoes = []string{e1.ID}
+	for _, e := range []Entry{e1, e2} {
+		if err := Append(path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != e1.ID || entries[0].Reason != "TICKET-1" || entries[1].Undoes[0] != e1.ID {
+		t.Errorf("Read: got %+v", entries)
+	}
+	if e1.ID == e2.ID {
+		t.Error("entries got the same ID")
+	}
+}
+
+func TestUndoablePlan(t *testing.T) {
+	const f = "/etc/pg_hba.conf"
+	entries := []Entry{
+		{ID: "1", Files: []FileChange{{Path: f, Before: "h0", After: "h1", Backup: "b0"}}},
+		{ID: "2", Files: []FileChange{{Path: f, Before: "h1", After: "h2", Backup: "b1"}}},
+		{ID: "3", Files: []FileChange{{Path: f, Before: "h2", After: "h3", Backup: "b2"}}},
+		{ID: "4", Files: []FileChange{{Path: f, Before: "h3", After: "h2", Backup: "b3"}}, Undoes: []string{"3"}},
+	}
+
+	selected, err := Undoable(entries, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selected[0].ID != "2" || selected[1].ID != "1" {
+		t.Errorf("Undoable: got %s, %s; want 2, 1", selected[0].ID, selected[1].ID)
+	}
+	if _, err := Undoable(entries, 3); err == nil {
+		t.Error("Undoable(3) with two undoable entries should fail")
+	}
+
+	plan, err := Plan(selected, map[string]string{f: "h2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := plan[f]; c.Before != "h0" || c.Backup != "b0" {
+		t.Errorf("Plan: got %+v, want the change from h0", c)
+	}
+
+	var diverged *DivergedError
+	if _, err := Plan(selected, map[string]string{f: "edited"}); !errors.As(err, &diverged) || diverged.Entry.ID != "2" {
+		t.Errorf("Plan of a changed file: got %v, want DivergedError for entry 2", err)
+	}
+}
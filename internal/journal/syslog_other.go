@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package journal
+
+import "errors"
+
+// Syslog is not available on this platform.
+func Syslog(e Entry) error {
+	return errors.New("syslog is not supported on this platform")
+}
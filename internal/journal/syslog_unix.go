@@ -0,0 +1,22 @@
+//go:build !windows && !plan9
+
+package journal
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// Syslog sends e to the local syslog daemon (facility auth, priority notice, tag hbactl) as JSON.
+func Syslog(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_NOTICE, "hbactl")
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return w.Notice(string(line))
+}
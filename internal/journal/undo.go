@@ -0,0 +1,61 @@
+package journal
+
+import (
+	"fmt"
+	"time"
+)
+
+// Undoable returns the last n entries that can be undone, newest first: entries that are not
+// themselves undos and have not been undone yet.
+func Undoable(entries []Entry, n int) ([]Entry, error) {
+	undone := map[string]bool{}
+	for _, e := range entries {
+		for _, id := range e.Undoes {
+			undone[id] = true
+		}
+	}
+	var selected []Entry
+	for i := len(entries) - 1; i >= 0 && len(selected) < n; i-- {
+		e := entries[i]
+		if len(e.Undoes) > 0 || undone[e.ID] {
+			continue
+		}
+		selected = append(selected, e)
+	}
+	if len(selected) < n {
+		return nil, fmt.Errorf("only %d change(s) can be undone", len(selected))
+	}
+	return selected, nil
+}
+
+// DivergedError is returned by Plan when a file no longer holds what an entry left in it: it was
+// changed outside hbactl (or by a run that was not journaled) since.
+type DivergedError struct {
+	Path  string
+	Entry Entry
+}
+
+func (e *DivergedError) Error() string {
+	return fmt.Sprintf("%s has changed since entry %s (%s) was made; it cannot be undone safely", e.Path, e.Entry.ID, e.Entry.Time.Local().Format(time.DateTime))
+}
+
+// Plan returns, for each file changed by entries (newest first, see Undoable), the change whose
+// Before content it must be restored to: the oldest one. current holds the SHA-256 of each file as
+// it is now; each entry must have left the file as the next one (or current) found it.
+func Plan(entries []Entry, current map[string]string) (map[string]FileChange, error) {
+	expect := map[string]string{}
+	for path, h := range current {
+		expect[path] = h
+	}
+	plan := map[string]FileChange{}
+	for _, e := range entries {
+		for _, c := range e.Files {
+			if expect[c.Path] != c.After {
+				return nil, &DivergedError{Path: c.Path, Entry: e}
+			}
+			expect[c.Path] = c.Before
+			plan[c.Path] = c
+		}
+	}
+	return plan, nil
+}
@@ -2,6 +2,7 @@ package pg
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -38,6 +39,15 @@ func (c *Client) HBAFilePath(ctx context.Context) (string, error) {
 	return path, err
 }
 
+// ServerVersionNum returns the server version as a number (e.g. 160002 for 16.2) from SHOW server_version_num.
+func (c *Client) ServerVersionNum(ctx context.Context) (int, error) {
+	var s string
+	if err := c.pool.QueryRow(ctx, "SHOW server_version_num").Scan(&s); err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
+
 // HBAFileError describes a syntax error in pg_hba.conf reported by pg_hba_file_rules.
 type HBAFileError struct {
 	LineNumber int
@@ -67,3 +77,49 @@ func (c *Client) HBAFileErrors(ctx context.Context) ([]HBAFileError, error) {
 	}
 	return errs, rows.Err()
 }
+
+// Session is a client connection to the server, from pg_stat_activity.
+type Session struct {
+	User       string
+	Database   string
+	ClientAddr string // empty for a Unix-domain socket
+	SSL        bool
+	GSSEnc     *bool // nil before PostgreSQL 12, which has no pg_stat_gssapi
+}
+
+// Sessions returns the distinct client connections to the server (user, database, address and
+// encryption), e.g. to check which pg_hba.conf rule each of them would match. Background workers
+// and replication connections are left out.
+func (c *Client) Sessions(ctx context.Context) ([]Session, error) {
+	num, err := c.ServerVersionNum(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gss, join, filter := "NULL::boolean", "", ""
+	if num >= 120000 {
+		gss, join = "coalesce(g.encrypted, false)", "LEFT JOIN pg_stat_gssapi g ON g.pid = a.pid"
+	}
+	if num >= 100000 {
+		filter = "AND a.backend_type = 'client backend'"
+	}
+	rows, err := c.pool.Query(ctx, `
+		SELECT DISTINCT a.usename, a.datname, coalesce(host(a.client_addr), ''), coalesce(s.ssl, false), `+gss+`
+		FROM pg_stat_activity a
+		LEFT JOIN pg_stat_ssl s ON s.pid = a.pid
+		`+join+`
+		WHERE a.usename IS NOT NULL AND a.datname IS NOT NULL `+filter+`
+		ORDER BY 1, 2, 3`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.User, &s.Database, &s.ClientAddr, &s.SSL, &s.GSSEnc); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
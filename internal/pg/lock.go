@@ -0,0 +1,158 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AdvisoryLockKey is the pg_advisory_lock key every hbactl run takes while it edits pg_hba.conf or
+// reloads the server ("hbactl" in ASCII). Advisory locks are per database: runs coordinate when they
+// connect to the same database.
+const AdvisoryLockKey int64 = 0x6862_6163_746c
+
+// advisoryLockPollInterval is how often a busy advisory lock is retried.
+const advisoryLockPollInterval = 100 * time.Millisecond
+
+// AdvisoryLock is a session-level advisory lock on AdvisoryLockKey. It lives on a connection taken
+// out of the pool, which is kept until Release.
+type AdvisoryLock struct {
+	conn *pgxpool.Conn
+}
+
+// LockHolder is a session holding, or waiting for, the advisory lock (from pg_locks and
+// pg_stat_activity).
+type LockHolder struct {
+	PID          int
+	Granted      bool // false: waiting for the lock
+	User         string
+	Application  string
+	ClientAddr   string // empty for a Unix-socket connection
+	BackendStart time.Time
+}
+
+// String describes the session, e.g. "pid 4242, user alice, application hbactl on jump1, client 10.0.0.5".
+func (h LockHolder) String() string {
+	parts := []string{fmt.Sprintf("pid %d", h.PID)}
+	if h.User != "" {
+		parts = append(parts, "user "+h.User)
+	}
+	if h.Application != "" {
+		parts = append(parts, "application "+h.Application)
+	}
+	if h.ClientAddr != "" {
+		parts = append(parts, "client "+h.ClientAddr)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// AdvisoryLockedError is returned by AcquireAdvisoryLock when another session holds the lock past
+// the timeout.
+type AdvisoryLockedError struct {
+	Holders []LockHolder // sessions holding the lock, if they could be read
+}
+
+func (e *AdvisoryLockedError) Error() string {
+	if len(e.Holders) == 0 {
+		return "the server lock is held by another session"
+	}
+	return fmt.Sprintf("the server lock is held by another session (%s)", e.Holders[0])
+}
+
+// AcquireAdvisoryLock takes the advisory lock, waiting up to timeout for another session to release
+// it. The session's application_name is set to "hbactl on HOST", so LockHolders shows where the
+// holder runs.
+func (c *Client) AcquireAdvisoryLock(ctx context.Context, timeout time.Duration) (*AdvisoryLock, error) {
+	conn, err := c.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(ctx, "SELECT set_config('application_name', $1, false)", applicationName()); err != nil {
+		conn.Release()
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		var ok bool
+		if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", AdvisoryLockKey).Scan(&ok); err != nil {
+			conn.Release()
+			return nil, err
+		}
+		if ok {
+			return &AdvisoryLock{conn: conn}, nil
+		}
+		if !time.Now().Before(deadline) {
+			conn.Release()
+			holders, _ := c.LockHolders(ctx)
+			var granted []LockHolder
+			for _, h := range holders {
+				if h.Granted {
+					granted = append(granted, h)
+				}
+			}
+			return nil, &AdvisoryLockedError{Holders: granted}
+		}
+		time.Sleep(advisoryLockPollInterval)
+	}
+}
+
+// Release releases the lock and returns its connection to the pool.
+func (l *AdvisoryLock) Release(ctx context.Context) error {
+	if l == nil || l.conn == nil {
+		return nil
+	}
+	_, err := l.conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", AdvisoryLockKey)
+	if err != nil {
+		// The lock ends with the session: do not hand it back to the pool still holding it.
+		l.conn.Conn().Close(ctx)
+	}
+	l.conn.Release()
+	l.conn = nil
+	return err
+}
+
+// LockHolders returns the sessions holding (first) or waiting for the advisory lock in the current
+// database.
+func (c *Client) LockHolders(ctx context.Context) ([]LockHolder, error) {
+	// A bigint advisory key is stored as classid (high 32 bits) and objid (low 32 bits), objsubid 1.
+	rows, err := c.pool.Query(ctx, `
+		SELECT l.pid, l.granted, coalesce(a.usename::text, ''), coalesce(a.application_name, ''),
+		       coalesce(host(a.client_addr), ''), a.backend_start
+		FROM pg_locks l
+		LEFT JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory'
+		  AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+		  AND l.classid::bigint = $1 AND l.objid::bigint = $2 AND l.objsubid = 1
+		ORDER BY l.granted DESC, l.pid`,
+		AdvisoryLockKey>>32, AdvisoryLockKey&0xffffffff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var holders []LockHolder
+	for rows.Next() {
+		var h LockHolder
+		var start *time.Time
+		if err := rows.Scan(&h.PID, &h.Granted, &h.User, &h.Application, &h.ClientAddr, &start); err != nil {
+			return nil, err
+		}
+		if start != nil {
+			h.BackendStart = *start
+		}
+		holders = append(holders, h)
+	}
+	return holders, rows.Err()
+}
+
+// applicationName identifies this run in pg_stat_activity.
+func applicationName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "hbactl"
+	}
+	return "hbactl on " + host
+}